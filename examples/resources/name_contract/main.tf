@@ -0,0 +1,35 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+# the name stays reserved for the twin even when the gateway is destroyed or moved to another node
+resource "grid_name_contract" "example" {
+  name = "example3"
+}
+
+resource "grid_scheduler" "sched" {
+  requests {
+    name          = "gateway"
+    public_config = true
+    yggdrasil     = false
+    wireguard     = false
+  }
+}
+
+resource "grid_name_proxy" "p1" {
+  node          = grid_scheduler.sched.nodes["gateway"]
+  name          = grid_name_contract.example.name
+  name_contract = grid_name_contract.example.contract_id
+  backends      = [format("http://69.164.223.208")]
+}
+
+output "fqdn" {
+  value = grid_name_proxy.p1.fqdn
+}
@@ -0,0 +1,66 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+locals {
+  name = "webvm"
+  # a node of the farm that lists the public ip below
+  node = 11
+}
+
+resource "random_bytes" "mycelium_key" {
+  length = 32
+}
+
+resource "grid_network" "net" {
+  name        = local.name
+  nodes       = [local.node]
+  ip_range    = "10.1.0.0/16"
+  description = "network of the web vm"
+  mycelium_keys = {
+    format("%s", local.node) = random_bytes.mycelium_key.hex
+  }
+}
+
+resource "grid_deployment" "d1" {
+  name         = local.name
+  node         = local.node
+  network_name = grid_network.net.name
+  vms {
+    name       = "web"
+    flist      = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu        = 1
+    memory     = 1024
+    entrypoint = "/sbin/zinit init"
+    env_vars = {
+      SSH_KEY = file("~/.ssh/id_rsa.pub")
+    }
+    publicip = true
+    # the address the DNS records point to, checked to be free at plan time
+    publicip_address = "185.206.122.33/24"
+  }
+  vms {
+    name       = "worker"
+    flist      = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu        = 1
+    memory     = 1024
+    entrypoint = "/sbin/zinit init"
+    env_vars = {
+      SSH_KEY = file("~/.ssh/id_rsa.pub")
+    }
+    publicip = true
+    # any free ip of the farm behind this gateway
+    publicip_gateway = "185.206.122.1"
+  }
+}
+
+output "web_public_ip" {
+  value = grid_deployment.d1.vms[0].computedip
+}
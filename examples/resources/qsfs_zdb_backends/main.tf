@@ -0,0 +1,96 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "random_bytes" "mycelium_ip_seed" {
+  length = 6
+}
+
+resource "random_bytes" "mycelium_key" {
+  length = 32
+}
+
+resource "grid_scheduler" "sched" {
+  requests {
+    name      = "node"
+    cru       = 2
+    sru       = 10 * 1024
+    mru       = 1024
+    yggdrasil = true
+    wireguard = false
+  }
+  requests {
+    name      = "zdbs1"
+    hru       = 3 * 10 * 1024
+    yggdrasil = true
+    wireguard = false
+  }
+  requests {
+    name      = "zdbs2"
+    hru       = 3 * 10 * 1024
+    yggdrasil = true
+    wireguard = false
+  }
+}
+
+resource "grid_network" "net1" {
+  name        = "network"
+  nodes       = [grid_scheduler.sched.nodes["node"]]
+  ip_range    = "10.1.0.0/16"
+  description = "qsfs network"
+  mycelium_keys = {
+    format("%s", grid_scheduler.sched.nodes["node"]) = random_bytes.mycelium_key.hex
+  }
+}
+
+resource "grid_deployment" "qsfs" {
+  node         = grid_scheduler.sched.nodes["node"]
+  network_name = grid_network.net1.name
+  qsfs {
+    name                  = "qsfs"
+    cache                 = 10240 # 10 GB
+    minimal_shards        = 2
+    expected_shards       = 4
+    redundant_groups      = 1
+    redundant_nodes       = 0
+    max_zdb_data_dir_size = 512 # 512 MB
+    zdb_backends {
+      nodes         = [grid_scheduler.sched.nodes["zdbs1"], grid_scheduler.sched.nodes["zdbs2"]]
+      data_zdb_size = 10
+    }
+  }
+  vms {
+    name             = "vm"
+    flist            = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu              = 2
+    memory           = 1024
+    entrypoint       = "/sbin/zinit init"
+    mycelium_ip_seed = random_bytes.mycelium_ip_seed.hex
+    env_vars = {
+      SSH_KEY = file("~/.ssh/id_rsa.pub")
+    }
+    mounts {
+      name        = "qsfs"
+      mount_point = "/qsfs"
+    }
+  }
+}
+
+output "metrics" {
+  value = grid_deployment.qsfs.qsfs[0].metrics_endpoint
+}
+
+output "data_backends" {
+  value = grid_deployment.qsfs.qsfs[0].zdb_backends[0].data_backends
+}
+
+output "mycelium_ip" {
+  value = grid_deployment.qsfs.vms[0].mycelium_ip
+}
@@ -0,0 +1,39 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_scheduler" "sched" {
+  requests {
+    name      = "dedicated"
+    cru       = 8
+    mru       = 16 * 1024
+    sru       = 100 * 1024
+    dedicated = true
+  }
+}
+
+resource "grid_rent_contract" "dedicated" {
+  node = grid_scheduler.sched.nodes["dedicated"]
+}
+
+resource "grid_zdb" "backup" {
+  name = "backup"
+  # depending on the rent contract makes the zdb contract go first on destroy
+  node = grid_rent_contract.dedicated.node
+  size = 50
+}
+
+output "rent_contract_id" {
+  value = grid_rent_contract.dedicated.contract_id
+}
+
+output "monthly_price" {
+  value = grid_rent_contract.dedicated.monthly_price
+}
@@ -0,0 +1,60 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+variable "api_token" {
+  type      = string
+  sensitive = true
+}
+
+locals {
+  name = "securevm"
+}
+
+resource "grid_scheduler" "sched" {
+  requests {
+    name = "node1"
+    cru  = 2
+    sru  = 1024
+    mru  = 1024
+  }
+}
+
+resource "grid_network" "net1" {
+  name        = local.name
+  nodes       = [grid_scheduler.sched.nodes["node1"]]
+  ip_range    = "10.1.0.0/16"
+  description = "network of the vm with secure env vars"
+}
+
+resource "grid_deployment" "d1" {
+  name         = local.name
+  node         = grid_scheduler.sched.nodes["node1"]
+  network_name = grid_network.net1.name
+  vms {
+    name       = "vm1"
+    flist      = "https://hub.grid.tf/tf-official-apps/base:latest.flist"
+    cpu        = 1
+    memory     = 1024
+    entrypoint = "/sbin/zinit init"
+    ssh_keys   = [file("~/.ssh/id_rsa.pub")]
+    env_vars = {
+      API_URL = "https://api.example.com"
+    }
+    # hidden in plans, only their sha256 hashes are kept in the state
+    secure_env_vars = {
+      API_TOKEN = var.api_token
+    }
+  }
+}
+
+output "vm1_ip" {
+  value = grid_deployment.d1.vms[0].ip
+}
@@ -0,0 +1,38 @@
+terraform {
+  required_providers {
+    grid = {
+      source = "threefoldtech/grid"
+    }
+  }
+}
+
+provider "grid" {
+}
+
+resource "grid_scheduler" "sched" {
+  requests {
+    name = "zdb"
+    hru  = 10 * 1024
+  }
+}
+
+resource "random_password" "zdb" {
+  length  = 16
+  special = false
+}
+
+resource "grid_zdb" "backup" {
+  name     = "backup"
+  node     = grid_scheduler.sched.nodes["zdb"]
+  size     = 10
+  mode     = "seq"
+  password = random_password.zdb.result
+}
+
+output "zdb_address" {
+  value = format("[%s]:%d", grid_zdb.backup.ips[1], grid_zdb.backup.port)
+}
+
+output "zdb_namespace" {
+  value = grid_zdb.backup.namespace
+}
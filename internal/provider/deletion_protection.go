@@ -0,0 +1,95 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const deletionProtectionKey = "deletion_protection"
+
+// deletionProtection holds the provider protect_all setting, the default deletion_protection of the resources
+type deletionProtection struct {
+	protectAll bool
+}
+
+// deletionProtectionSchema is the deletion_protection attribute of the resources whose contracts can be protected
+func deletionProtectionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Computed:    true,
+		Description: "Refuse to cancel the contracts of the resource while set, so destroying or replacing it, or an update that moves it off some of its nodes, fails. It has to be set to false and applied before the resource can be destroyed. Defaults to the `protect_all` setting of the provider.",
+	}
+}
+
+// withDeletionProtection refuses to delete a resource that has deletion_protection set in its state
+func withDeletionProtection(f resourceFunc) resourceFunc {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		if d.Get(deletionProtectionKey).(bool) {
+			return diag.Errorf("couldn't delete resource %s with error: deletion_protection is set, set it to false and apply before destroying or replacing the resource", d.Id())
+		}
+		return f(ctx, d, meta)
+	}
+}
+
+// checkUpdateProtection refuses an update that cancels some contracts of a resource that has deletion_protection set,
+// canceled describes the contracts the update would cancel
+func checkUpdateProtection(d *schema.ResourceData, canceled []string) diag.Diagnostics {
+	if len(canceled) == 0 || !d.Get(deletionProtectionKey).(bool) {
+		return nil
+	}
+	return diag.Errorf("couldn't update resource %s with error: deletion_protection is set and the update cancels the %s, set it to false to allow it", d.Id(), strings.Join(canceled, " and "))
+}
+
+// removedNodes returns the old nodes that are not used anymore, their contracts are canceled by the update
+func removedNodes(old, new []uint32) []uint32 {
+	removed := make([]uint32, 0)
+	for _, node := range old {
+		if node != 0 && !slices.Contains(new, node) && !slices.Contains(removed, node) {
+			removed = append(removed, node)
+		}
+	}
+	return removed
+}
+
+// nodeContractsCanceled describes the contracts on the removed nodes for checkUpdateProtection
+func nodeContractsCanceled(what string, removed []uint32) []string {
+	if len(removed) == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s on nodes %s", what, formatNodes(removed))}
+}
+
+// withDefault plans protect_all as the deletion_protection of the resource if it's not configured, then runs the
+// resource own diff customization
+func (p *deletionProtection) withDefault(customizeDiff schema.CustomizeDiffFunc) schema.CustomizeDiffFunc {
+	return func(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+		config := d.GetRawConfig()
+		configured := !config.IsNull() && !config.GetAttr(deletionProtectionKey).IsNull()
+		if !configured && (d.Id() == "" || d.Get(deletionProtectionKey).(bool) != p.protectAll) {
+			if err := d.SetNew(deletionProtectionKey, p.protectAll); err != nil {
+				return err
+			}
+		}
+
+		if customizeDiff == nil {
+			return nil
+		}
+		return customizeDiff(ctx, d, meta)
+	}
+}
+
+// protect makes protect_all the default deletion_protection of the resources that have the attribute
+func (p *deletionProtection) protect(resources map[string]*schema.Resource) {
+	for _, r := range resources {
+		if _, ok := r.Schema[deletionProtectionKey]; ok {
+			r.CustomizeDiff = p.withDefault(r.CustomizeDiff)
+		}
+	}
+}
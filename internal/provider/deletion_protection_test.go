@@ -0,0 +1,76 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDeletionProtection(t *testing.T) {
+	deleted := false
+	del := withDeletionProtection(func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		deleted = true
+		return nil
+	})
+
+	d := schema.TestResourceDataRaw(t, resourceNetwork().Schema, map[string]interface{}{"deletion_protection": true})
+	d.SetId("net")
+	diags := del(context.Background(), d, nil)
+	assert.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "deletion_protection is set")
+	assert.False(t, deleted)
+
+	assert.NoError(t, d.Set("deletion_protection", false))
+	assert.False(t, del(context.Background(), d, nil).HasError())
+	assert.True(t, deleted)
+}
+
+func TestDeletionProtectionDefault(t *testing.T) {
+	protection := &deletionProtection{protectAll: true}
+	resources := map[string]*schema.Resource{
+		"grid_name_proxy": resourceGatewayNameProxy(),
+		"grid_zdb":        resourceZDB(),
+	}
+	protection.protect(resources)
+	assert.NotContains(t, resources["grid_zdb"].Schema, "deletion_protection")
+
+	diff, err := resources["grid_name_proxy"].Diff(context.Background(), nil, terraform.NewResourceConfigRaw(map[string]interface{}{
+		"name":     "hamada",
+		"node":     11,
+		"backends": []interface{}{"http://1.1.1.1:9000"},
+	}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "true", diff.Attributes["deletion_protection"].New)
+}
+
+func TestRemovedNodes(t *testing.T) {
+	assert.Equal(t, []uint32{1, 4}, removedNodes([]uint32{1, 2, 1, 4, 0}, []uint32{2, 3}))
+	assert.Empty(t, removedNodes([]uint32{1, 2}, []uint32{2, 1}))
+}
+
+func TestCheckUpdateProtection(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceNetwork().Schema, map[string]interface{}{"deletion_protection": true})
+	d.SetId("net")
+
+	assert.False(t, checkUpdateProtection(d, nodeContractsCanceled("network deployments", nil)).HasError())
+
+	diags := checkUpdateProtection(d, nodeContractsCanceled("network deployments", []uint32{1, 4}))
+	assert.True(t, diags.HasError())
+	assert.Contains(t, diags[0].Summary, "deletion_protection is set")
+	assert.Contains(t, diags[0].Summary, "network deployments on nodes")
+
+	assert.NoError(t, d.Set("deletion_protection", false))
+	assert.False(t, checkUpdateProtection(d, nodeContractsCanceled("network deployments", []uint32{1, 4})).HasError())
+}
+
+func TestK8sNodes(t *testing.T) {
+	master := []interface{}{map[string]interface{}{"node": 1}}
+	workers := []interface{}{map[string]interface{}{"node": 2}, map[string]interface{}{"node": 1}}
+	assert.Equal(t, []uint32{1, 2, 1}, k8sNodes(master, workers))
+	assert.Empty(t, k8sNodes([]interface{}{}, []interface{}{}))
+}
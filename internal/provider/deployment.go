@@ -5,24 +5,27 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"maps"
+	"net"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 	client "github.com/threefoldtech/tfgrid-sdk-go/grid-client/node"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
-func newDeploymentFromSchema(ctx context.Context, d *schema.ResourceData, ncPool client.NodeClientGetter, sub subi.SubstrateExt) (*workloads.Deployment, error) {
+// newDeploymentsFromSchema groups the deployment workloads into one deployment per node, the workloads without a node
+// are placed on the deployment node, which always gets a deployment
+func newDeploymentsFromSchema(ctx context.Context, d *schema.ResourceData, ncPool client.NodeClientGetter, sub subi.SubstrateExt, networks *state.NetworkState) ([]*workloads.Deployment, error) {
 	networkName := d.Get("network_name").(string)
-	nodeID := uint32(d.Get("node").(int))
-
-	light, err := isZosLight(ctx, nodeID, ncPool, sub)
-	if err != nil {
-		return nil, err
-	}
+	defaultNode := uint32(d.Get("node").(int))
 
 	name := d.Get("name").(string)
 	solutionType := d.Get("solution_type").(string)
@@ -30,29 +33,141 @@ func newDeploymentFromSchema(ctx context.Context, d *schema.ResourceData, ncPool
 		solutionType = fmt.Sprintf("vm/%s", name)
 	}
 
-	disks := make([]workloads.Disk, 0)
+	solutionProviderVal := uint64(d.Get("solution_provider").(int))
+	var solutionProvider *uint64
+	if solutionProviderVal == 0 {
+		solutionProvider = nil
+	} else {
+		solutionProvider = &solutionProviderVal
+	}
+
+	contracts, err := nodeDeploymentIDs(d)
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make(map[uint32]*workloads.Deployment)
+	lightNodes := make(map[uint32]bool)
+	nodeDeployment := func(nodeID uint32) (*workloads.Deployment, error) {
+		if dl, ok := dls[nodeID]; ok {
+			return dl, nil
+		}
+
+		light, err := isZosLight(ctx, nodeID, ncPool, sub)
+		if err != nil {
+			return nil, err
+		}
+		lightNodes[nodeID] = light
+
+		var ipRange string
+		if networkName != "" {
+			network := networks.GetNetwork(networkName)
+			ipRange = network.GetNodeSubnet(nodeID)
+		}
+
+		nodeDeploymentID := map[uint32]uint64{}
+		if contractID := contracts[nodeID]; contractID != 0 {
+			nodeDeploymentID[nodeID] = contractID
+		}
+
+		dls[nodeID] = &workloads.Deployment{
+			Name:             name,
+			NodeID:           nodeID,
+			SolutionProvider: solutionProvider,
+			SolutionType:     solutionType,
+			Disks:            make([]workloads.Disk, 0),
+			Volumes:          make([]workloads.Volume, 0),
+			Vms:              make([]workloads.VM, 0),
+			VmsLight:         make([]workloads.VMLight, 0),
+			QSFS:             make([]workloads.QSFS, 0),
+			Zdbs:             make([]workloads.ZDB, 0),
+			NetworkName:      networkName,
+			IPrange:          ipRange,
+			ContractID:       contracts[nodeID],
+			NodeDeploymentID: nodeDeploymentID,
+		}
+		return dls[nodeID], nil
+	}
+
+	if _, err := nodeDeployment(defaultNode); err != nil {
+		return nil, err
+	}
+
 	for _, disk := range d.Get("disks").([]interface{}) {
-		d, err := workloads.NewWorkloadFromMap(disk.(map[string]interface{}), &workloads.Disk{})
+		diskMap := disk.(map[string]interface{})
+		dl, err := nodeDeployment(workloadNode(diskMap, defaultNode))
+		if err != nil {
+			return nil, err
+		}
+		d, err := workloads.NewWorkloadFromMap(diskMap, &workloads.Disk{})
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create workload from disk map")
 		}
-		disks = append(disks, *(d.(*workloads.Disk)))
+		dl.Disks = append(dl.Disks, *(d.(*workloads.Disk)))
+	}
+
+	for _, volume := range d.Get("volumes").([]interface{}) {
+		volumeMap := volume.(map[string]interface{})
+		dl, err := nodeDeployment(workloadNode(volumeMap, defaultNode))
+		if err != nil {
+			return nil, err
+		}
+		v, err := workloads.NewWorkloadFromMap(volumeMap, &workloads.Volume{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create workload from volume map")
+		}
+		dl.Volumes = append(dl.Volumes, *(v.(*workloads.Volume)))
 	}
 
-	zdbs := make([]workloads.ZDB, 0)
 	for _, zdb := range d.Get("zdbs").([]interface{}) {
-		z, err := workloads.NewWorkloadFromMap(zdb.(map[string]interface{}), &workloads.ZDB{})
+		zdbMap := zdb.(map[string]interface{})
+		dl, err := nodeDeployment(workloadNode(zdbMap, defaultNode))
+		if err != nil {
+			return nil, err
+		}
+		z, err := workloads.NewWorkloadFromMap(zdbMap, &workloads.ZDB{})
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create workload from zdb map")
 		}
-		zdbs = append(zdbs, *(z.(*workloads.ZDB)))
+		dl.Zdbs = append(dl.Zdbs, *(z.(*workloads.ZDB)))
+	}
+
+	for _, qsfsdata := range d.Get("qsfs").([]interface{}) {
+		qsfsI := qsfsdata.(map[string]interface{})
+		dl, err := nodeDeployment(workloadNode(qsfsI, defaultNode))
+		if err != nil {
+			return nil, err
+		}
+		if metadata := qsfsI["metadata"].([]interface{}); len(metadata) != 0 {
+			qsfsI["metadata"] = metadata[0]
+		} else {
+			// generated from the zdb_backends
+			delete(qsfsI, "metadata")
+		}
+		q, err := workloads.NewWorkloadFromMap(qsfsI, &workloads.QSFS{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create workload from qsfs map")
+		}
+		dl.QSFS = append(dl.QSFS, *q.(*workloads.QSFS))
 	}
 
-	vms := make([]workloads.VM, 0)
-	vmsLight := make([]workloads.VMLight, 0)
-	for _, vm := range d.Get("vms").([]interface{}) {
+	vms := d.Get("vms").([]interface{})
+	secureEnv := configuredSecureEnv(d)
+	for _, vm := range vms {
 		vmMap := vm.(map[string]interface{})
+		nodeID := workloadNode(vmMap, defaultNode)
+		dl, err := nodeDeployment(nodeID)
+		if err != nil {
+			return nil, err
+		}
+
 		vmMap["network_name"] = networkName
+		if err := setVMSecureEnv(vmMap, secureEnv[vmMap["name"].(string)]); err != nil {
+			return nil, err
+		}
+		if err := setVMInitEnv(vmMap); err != nil {
+			return nil, err
+		}
 
 		myceliumIPSeed := vmMap["mycelium_ip_seed"].(string)
 		myceliumIPSeedBytes, err := hex.DecodeString(myceliumIPSeed)
@@ -61,7 +176,7 @@ func newDeploymentFromSchema(ctx context.Context, d *schema.ResourceData, ncPool
 		}
 		vmMap["mycelium_ip_seed"] = myceliumIPSeedBytes
 
-		if light {
+		if lightNodes[nodeID] {
 			v, err := workloads.NewWorkloadFromMap(vmMap, &workloads.VMLight{})
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to create workload from vm map")
@@ -69,7 +184,7 @@ func newDeploymentFromSchema(ctx context.Context, d *schema.ResourceData, ncPool
 
 			vmWorkload := *v.(*workloads.VMLight)
 			vmWorkload.NodeID = nodeID
-			vmsLight = append(vmsLight, vmWorkload)
+			dl.VmsLight = append(dl.VmsLight, vmWorkload)
 			continue
 		}
 
@@ -80,168 +195,680 @@ func newDeploymentFromSchema(ctx context.Context, d *schema.ResourceData, ncPool
 
 		vmWorkload := *v.(*workloads.VM)
 		vmWorkload.NodeID = nodeID
-		vms = append(vms, vmWorkload)
+		dl.Vms = append(dl.Vms, vmWorkload)
 	}
 
-	// TODO: ip_range
-	// err = r.Set("ip_range", d.IPRange)
-	// if err != nil {
-	// 	errors = multierror.Append(errors, err)
-	// }
+	mountables := deploymentMountables(d.Get("disks").([]interface{}), d.Get("volumes").([]interface{}), d.Get("qsfs").([]interface{}), defaultNode)
+	if err := validateVMMounts(vms, mountables, defaultNode, lightNodes); err != nil {
+		return nil, err
+	}
 
-	qsfs := make([]workloads.QSFS, 0)
-	for _, qsfsdata := range d.Get("qsfs").([]interface{}) {
-		qsfsI := qsfsdata.(map[string]interface{})
-		qsfsI["metadata"] = qsfsI["metadata"].([]interface{})[0]
-		q, err := workloads.NewWorkloadFromMap(qsfsdata.(map[string]interface{}), &workloads.QSFS{})
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create workload from qsfs map")
+	return sortedDeployments(dls, defaultNode), nil
+}
+
+// sortedDeployments returns the deployments of the deployment node first, then the others by node id
+func sortedDeployments(dls map[uint32]*workloads.Deployment, defaultNode uint32) []*workloads.Deployment {
+	sorted := make([]*workloads.Deployment, 0, len(dls))
+	for _, dl := range dls {
+		sorted = append(sorted, dl)
+	}
+	slices.SortFunc(sorted, func(a, b *workloads.Deployment) int {
+		switch {
+		case a.NodeID == b.NodeID:
+			return 0
+		case a.NodeID == defaultNode:
+			return -1
+		case b.NodeID == defaultNode:
+			return 1
 		}
-		qsfs = append(qsfs, *q.(*workloads.QSFS))
+		return int(a.NodeID) - int(b.NodeID)
+	})
+	return sorted
+}
+
+// workloadNode returns the node a workload is placed on, which is the deployment node unless the workload sets its own.
+// Zero is returned if the node isn't known yet
+func workloadNode(w map[string]interface{}, defaultNode uint32) uint32 {
+	node, _ := w["node"].(int)
+	switch {
+	case node < 0:
+		return 0
+	case node == 0:
+		return defaultNode
 	}
+	return uint32(node)
+}
 
-	solutionProviderVal := uint64(d.Get("solution_provider").(int))
-	var solutionProvider *uint64
-	if solutionProviderVal == 0 {
-		solutionProvider = nil
-	} else {
-		solutionProvider = &solutionProviderVal
+// stateReader reads the attributes of a resource, it's satisfied by both schema.ResourceData and schema.ResourceDiff
+type stateReader interface {
+	Id() string
+	Get(key string) interface{}
+	GetChange(key string) (interface{}, interface{})
+}
+
+// nodeDeploymentIDs returns the deployment contract of each node, resources created before the workloads could be
+// placed on several nodes only track the contract on the deployment node as their id
+func nodeDeploymentIDs(d stateReader) (map[uint32]uint64, error) {
+	contracts := make(map[uint32]uint64)
+	for node, id := range d.Get("node_deployment_id").(map[string]interface{}) {
+		nodeID, err := strconv.ParseUint(node, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse node id '%s'", node)
+		}
+		contracts[uint32(nodeID)] = uint64(id.(int))
 	}
 
-	var contractID uint64
-	nodeDeploymentID := map[uint32]uint64{}
-	if d.Id() != "" {
-		contractID, err = strconv.ParseUint(d.Id(), 10, 64)
+	if len(contracts) == 0 && d.Id() != "" {
+		contractID, err := strconv.ParseUint(d.Id(), 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		nodeDeploymentID[nodeID] = contractID
+		if contractID != 0 {
+			oldNode, _ := d.GetChange("node")
+			contracts[uint32(oldNode.(int))] = contractID
+		}
 	}
+	return contracts, nil
+}
+
+const (
+	mountTypeDisk   = "disk"
+	mountTypeVolume = "volume"
+	mountTypeQSFS   = "qsfs"
+)
+
+// lightMountTypes are the mount types zos light nodes can serve, they don't run the qsfs daemon
+var lightMountTypes = []string{mountTypeDisk, mountTypeVolume}
 
-	dl := workloads.Deployment{
-		Name:             name,
-		NodeID:           nodeID,
-		SolutionProvider: solutionProvider,
-		SolutionType:     solutionType,
-		Disks:            disks,
-		Vms:              vms,
-		VmsLight:         vmsLight,
-		QSFS:             qsfs,
-		Zdbs:             zdbs,
-		NetworkName:      networkName,
-		ContractID:       contractID,
-		NodeDeploymentID: nodeDeploymentID,
+// mountable is a deployment workload that vms can mount
+type mountable struct {
+	typ  string
+	node uint32
+}
+
+// deploymentMountables maps the names of the deployment workloads that vms can mount to their types and nodes
+func deploymentMountables(disks, volumes, qsfs []interface{}, defaultNode uint32) map[string]mountable {
+	mountables := make(map[string]mountable)
+	add := func(workloads []interface{}, typ string) {
+		for _, w := range workloads {
+			wMap := w.(map[string]interface{})
+			name := wMap["name"].(string)
+			if _, ok := mountables[name]; name != "" && !ok {
+				mountables[name] = mountable{typ: typ, node: workloadNode(wMap, defaultNode)}
+			}
+		}
 	}
+	add(disks, mountTypeDisk)
+	add(volumes, mountTypeVolume)
+	add(qsfs, mountTypeQSFS)
+	return mountables
+}
+
+// validateVMMounts makes sure the vm mounts reference disks, volumes or qsfss of the deployment on the vm node that
+// the node can serve, that a disk is only mounted by one vm, and that a vm doesn't use a mount point twice.
+// Nodes that are not known yet are zero and skip the node checks
+func validateVMMounts(vms []interface{}, mountables map[string]mountable, defaultNode uint32, lightNodes map[uint32]bool) error {
+	var errs error
+	diskMounts := make(map[string]string)
+	for i, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		vmNode := workloadNode(vmMap, defaultNode)
+		mounts, _ := vmMap["mounts"].([]interface{})
+		mountPoints := make(map[string]string)
+		for j, mount := range mounts {
+			mountMap := mount.(map[string]interface{})
+			path := fmt.Sprintf("vms.%d.mounts.%d", i, j)
+
+			if mountPoint := mountMap["mount_point"].(string); mountPoint != "" {
+				if other, ok := mountPoints[mountPoint]; ok {
+					errs = multierror.Append(errs, fmt.Errorf("%s.mount_point: %s is already used by %s", path, mountPoint, other))
+				}
+				mountPoints[mountPoint] = path
+			}
+
+			name := mountMap["name"].(string)
+			if name == "" {
+				// not known yet
+				continue
+			}
+			m, ok := mountables[name]
+			if !ok {
+				errs = multierror.Append(errs, fmt.Errorf("%s.name: %s is not a disk, volume or qsfs of the deployment", path, name))
+				continue
+			}
+			if m.typ == mountTypeDisk {
+				if other, ok := diskMounts[name]; ok {
+					errs = multierror.Append(errs, fmt.Errorf("%s.name: disk %s is already mounted by %s", path, name, other))
+				}
+				diskMounts[name] = path
+			}
+			if vmNode != 0 && m.node != 0 && vmNode != m.node {
+				errs = multierror.Append(errs, fmt.Errorf("%s.name: %s %s is on node %d, not on the vm node %d", path, m.typ, name, m.node, vmNode))
+				continue
+			}
+			if lightNodes[vmNode] && !slices.Contains(lightMountTypes, m.typ) {
+				errs = multierror.Append(errs, fmt.Errorf("%s.name: %s %s can't be served by zos light nodes, use a %s instead", path, m.typ, name, strings.Join(lightMountTypes, " or ")))
+			}
+		}
+	}
+	return errs
+}
+
+// parseDeploymentImportID parses an import id of the form `contract_id` or `node_id:contract_id`, node id is zero if not given
+func parseDeploymentImportID(id string) (nodeID uint32, contractID uint64, err error) {
+	contract := id
+	if node, c, ok := strings.Cut(id, ":"); ok {
+		n, err := strconv.ParseUint(node, 10, 32)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "couldn't parse node id '%s'", node)
+		}
+		nodeID, contract = uint32(n), c
+	}
+
+	contractID, err = strconv.ParseUint(contract, 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "couldn't parse contract id '%s'", contract)
+	}
+	return nodeID, contractID, nil
+}
+
+// loadDeploymentFromContract fetches the deployment of a node contract owned by the twin from its node
+func loadDeploymentFromContract(ctx context.Context, tfPluginClient *deployer.TFPluginClient, nodeID uint32, contractID uint64) (*workloads.Deployment, error) {
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if !contract.ContractType.IsNodeContract {
+		return nil, fmt.Errorf("contract %d is not a node contract", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return nil, fmt.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+
+	contractNode := uint32(contract.ContractType.NodeContract.Node)
+	if nodeID != 0 && nodeID != contractNode {
+		return nil, fmt.Errorf("contract %d is on node %d, not node %d", contractID, contractNode, nodeID)
+	}
+
+	nodeClient, err := tfPluginClient.NcPool.GetNodeClient(tfPluginClient.SubstrateConn, contractNode)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get node client '%d'", contractNode)
+	}
+
+	zosDeployment, err := nodeClient.DeploymentGet(ctx, contractID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get deployment %d from node %d", contractID, contractNode)
+	}
+
+	dl, err := workloads.NewDeploymentFromZosDeployment(zosDeployment, contractNode)
+	if err != nil {
+		return nil, err
+	}
+
+	// the contract metadata is the source of truth for the deployment name and solution type
+	metadata, err := workloads.ParseDeploymentData(contract.ContractType.NodeContract.DeploymentData)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse metadata of contract %d", contractID)
+	}
+	dl.Name = metadata.Name
+	dl.SolutionType = metadata.ProjectName
+	dl.ContractID = contractID
+	dl.NodeDeploymentID = map[uint32]uint64{contractNode: contractID}
 
 	return &dl, nil
 }
 
-// syncContractsDeployments updates the terraform local state with the latest changes to workloads
-func syncContractsDeployments(r *schema.ResourceData, d *workloads.Deployment) (errors error) {
-	vms := make([]interface{}, 0)
-	disks := make([]interface{}, 0)
-	zdbs := make([]interface{}, 0)
-	qsfs := make([]interface{}, 0)
+// vmProviderKeys are the vm attributes that are only known to the provider, not to the deployed workloads
+var vmProviderKeys = []string{"wait_for", "ready_address", "publicip_address", "publicip_gateway", "publicip_range"}
 
-	for _, vm := range d.Vms {
-		vmMap, err := workloads.ToMap(vm)
-		if err != nil {
-			return err
+// mergeConfiguredVMs keeps the provider only attributes of the deployed vms, moves their secure env vars and ssh keys
+// env var back to their attributes, and orders them as the configured vms
+func mergeConfiguredVMs(configured []interface{}, deployed []interface{}) []interface{} {
+	deployedVMs := make(map[string]map[string]interface{})
+	for _, vm := range deployed {
+		vmMap := vm.(map[string]interface{})
+		deployedVMs[vmMap["name"].(string)] = vmMap
+	}
+
+	vms := make([]interface{}, 0, len(deployed))
+	added := make(map[string]bool)
+	for _, vm := range configured {
+		vmMap := vm.(map[string]interface{})
+		name := vmMap["name"].(string)
+		if deployedVM, ok := deployedVMs[name]; ok {
+			moveSecureEnv(deployedVM, vmMap)
+			moveVMInitEnv(deployedVM, vmMap)
+			for _, key := range vmProviderKeys {
+				deployedVM[key] = vmMap[key]
+			}
+			vms = append(vms, deployedVM)
+			added[name] = true
 		}
+	}
 
-		vmMap["mycelium_ip_seed"] = hex.EncodeToString(vm.MyceliumIPSeed)
-		delete(vmMap, "network_name")
-		vms = append(vms, vmMap)
+	for _, vm := range deployed {
+		vmMap := vm.(map[string]interface{})
+		if !added[vmMap["name"].(string)] {
+			moveSecureEnv(vmMap, nil)
+			moveVMInitEnv(vmMap, nil)
+			vms = append(vms, vmMap)
+		}
 	}
+	return vms
+}
 
-	for _, vm := range d.VmsLight {
-		vmMap, err := workloads.ToMap(vm)
-		if err != nil {
-			return err
+// deploymentWorkloadNames returns the names of the deployment workloads
+func deploymentWorkloadNames(dl *workloads.Deployment) []string {
+	names := make([]string, 0)
+	for _, vm := range dl.Vms {
+		names = append(names, vm.Name)
+	}
+	for _, vm := range dl.VmsLight {
+		names = append(names, vm.Name)
+	}
+	for _, disk := range dl.Disks {
+		names = append(names, disk.Name)
+	}
+	for _, volume := range dl.Volumes {
+		names = append(names, volume.Name)
+	}
+	for _, zdb := range dl.Zdbs {
+		names = append(names, zdb.Name)
+	}
+	for _, q := range dl.QSFS {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+// checkDeploymentHealth makes sure all expected workloads are in an ok state, a synced deployment only holds workloads in an ok state
+func checkDeploymentHealth(expected []string, dl *workloads.Deployment) error {
+	if dl.ContractID == 0 {
+		return fmt.Errorf("deployment contract doesn't exist")
+	}
+
+	synced := deploymentWorkloadNames(dl)
+	var errs error
+	for _, name := range expected {
+		if !slices.Contains(synced, name) {
+			errs = multierror.Append(errs, fmt.Errorf("workload %s is not ready", name))
 		}
+	}
+	return errs
+}
 
-		vmMap["mycelium_ip_seed"] = hex.EncodeToString(vm.MyceliumIPSeed)
-		delete(vmMap, "network_name")
-		vms = append(vms, vmMap)
+// vmsIPRange returns the node subnet the deployment vms got their private ips from
+func vmsIPRange(dl *workloads.Deployment) string {
+	ips := make([]string, 0)
+	for _, vm := range dl.Vms {
+		ips = append(ips, vm.IP)
+	}
+	for _, vm := range dl.VmsLight {
+		ips = append(ips, vm.IP)
 	}
 
-	for _, d := range d.Disks {
-		disk, err := workloads.ToMap(d)
-		if err != nil {
-			return err
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip).To4()
+		if parsed == nil {
+			continue
 		}
-		disks = append(disks, disk)
+		mask := net.CIDRMask(24, 32)
+		ipNet := net.IPNet{IP: parsed.Mask(mask), Mask: mask}
+		return ipNet.String()
 	}
+	return ""
+}
 
-	for _, z := range d.Zdbs {
-		zdb, err := workloads.ToMap(z)
-		if err != nil {
-			return err
+// validateVMsIP makes sure the private ips chosen by the user are usable host ips within the node ip range
+func validateVMsIP(dl *workloads.Deployment) error {
+	if dl.IPrange == "" {
+		return nil
+	}
+
+	_, ipRange, err := net.ParseCIDR(dl.IPrange)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse ip range '%s'", dl.IPrange)
+	}
+
+	vms := make(map[string]string)
+	for _, vm := range dl.Vms {
+		vms[vm.Name] = vm.IP
+	}
+	for _, vm := range dl.VmsLight {
+		vms[vm.Name] = vm.IP
+	}
+
+	var errs error
+	for name, ip := range vms {
+		if ip == "" {
+			continue
 		}
-		zdbs = append(zdbs, zdb)
+		parsed := net.ParseIP(ip).To4()
+		if parsed == nil {
+			errs = multierror.Append(errs, fmt.Errorf("ip '%s' of vm %s is not a valid IPv4", ip, name))
+			continue
+		}
+		if !ipRange.Contains(parsed) {
+			errs = multierror.Append(errs, fmt.Errorf("ip '%s' of vm %s is not in the node ip range '%s'", ip, name, dl.IPrange))
+			continue
+		}
+		// the first two hosts are reserved for the network and its gateway
+		if parsed[3] < 2 || parsed[3] == 255 {
+			errs = multierror.Append(errs, fmt.Errorf("ip '%s' of vm %s is reserved in the node ip range '%s'", ip, name, dl.IPrange))
+		}
+	}
+	return errs
+}
+
+// validateDeploymentsIP validates the vms ips of the deployment of each node
+func validateDeploymentsIP(dls []*workloads.Deployment) (errs error) {
+	for _, dl := range dls {
+		if err := validateVMsIP(dl); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return
+}
+
+// cancelNodeContracts cancels the deployment contracts of the given nodes, and returns the ones that couldn't be canceled
+func cancelNodeContracts(ctx context.Context, tfPluginClient *deployer.TFPluginClient, name string, contracts map[uint32]uint64) (map[uint32]uint64, error) {
+	remaining := maps.Clone(contracts)
+	var errs error
+	for node, contractID := range contracts {
+		dl := &workloads.Deployment{
+			Name:             name,
+			NodeID:           node,
+			ContractID:       contractID,
+			NodeDeploymentID: map[uint32]uint64{node: contractID},
+		}
+		if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, dl); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "couldn't cancel contract %d on node %d", contractID, node))
+			continue
+		}
+		delete(remaining, node)
 	}
+	return remaining, errs
+}
 
-	for _, q := range d.QSFS {
-		qs, err := workloads.ToMap(q)
+// nodeDeploymentIDMap converts the contracts of the nodes to the node_deployment_id attribute
+func nodeDeploymentIDMap(contracts map[uint32]uint64) map[string]interface{} {
+	ids := make(map[string]interface{})
+	for node, contractID := range contracts {
+		ids[fmt.Sprint(node)] = int(contractID)
+	}
+	return ids
+}
+
+// hasNodeContracts reports whether any node has a contract, e.g. left behind by a failed deployment
+func hasNodeContracts(contracts map[uint32]uint64) bool {
+	for _, contractID := range contracts {
+		if contractID != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentCanceledContracts describes the contracts an update cancels: the deployments on the nodes left by all the
+// workloads, and the qsfs backends on the nodes removed from their zdb_backends
+func deploymentCanceledContracts(d *schema.ResourceData, contracts map[uint32]uint64, dls []*workloads.Deployment) ([]string, error) {
+	oldNodes := make([]uint32, 0, len(contracts))
+	for node := range contracts {
+		oldNodes = append(oldNodes, node)
+	}
+	newNodes := make([]uint32, 0, len(dls))
+	for _, dl := range dls {
+		newNodes = append(newNodes, dl.NodeID)
+	}
+	canceled := nodeContractsCanceled("deployments", removedNodes(oldNodes, newNodes))
+
+	backendNodes := make(map[string][]uint32)
+	for _, q := range d.Get("qsfs").([]interface{}) {
+		qMap := q.(map[string]interface{})
+		if config := zdbBackendsConfig(qMap); config != nil {
+			backendNodes[qMap["name"].(string)] = parseUint32List(config["nodes"].([]interface{}))
+		}
+	}
+
+	previous := stateQSFSZDBBackends(d)
+	names := make([]string, 0, len(previous))
+	for name := range previous {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		backendContracts, err := zdbBackendsContracts(previous[name])
 		if err != nil {
-			return err
+			return nil, err
+		}
+		oldNodes := make([]uint32, 0, len(backendContracts))
+		for node := range backendContracts {
+			oldNodes = append(oldNodes, node)
+		}
+		canceled = append(canceled, nodeContractsCanceled(fmt.Sprintf("qsfs %s backends", name), removedNodes(oldNodes, backendNodes[name]))...)
+	}
+	return canceled, nil
+}
+
+// formatNodes lists the node ids in order, e.g. for error messages
+func formatNodes(nodes []uint32) string {
+	slices.Sort(nodes)
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, fmt.Sprint(node))
+	}
+	return strings.Join(ids, ", ")
+}
+
+// syncContractsDeployments updates the terraform local state with the latest changes to workloads of the deployments on each node
+func syncContractsDeployments(r *schema.ResourceData, dls []*workloads.Deployment, defaultNode uint32) (errors error) {
+	vms := make([]interface{}, 0)
+	disks := make([]interface{}, 0)
+	volumes := make([]interface{}, 0)
+	zdbs := make([]interface{}, 0)
+	qsfs := make([]interface{}, 0)
+	var ipRange string
+
+	for _, d := range dls {
+		nodeID := int(d.NodeID)
+		for _, vm := range d.Vms {
+			vmMap, err := workloads.ToMap(vm)
+			if err != nil {
+				return err
+			}
+
+			vmMap["mycelium_ip_seed"] = hex.EncodeToString(vm.MyceliumIPSeed)
+			vmMap["node"] = nodeID
+			delete(vmMap, "network_name")
+			vms = append(vms, vmMap)
+		}
+
+		for _, vm := range d.VmsLight {
+			vmMap, err := workloads.ToMap(vm)
+			if err != nil {
+				return err
+			}
+
+			vmMap["mycelium_ip_seed"] = hex.EncodeToString(vm.MyceliumIPSeed)
+			vmMap["node"] = nodeID
+			delete(vmMap, "network_name")
+			vms = append(vms, vmMap)
+		}
+
+		for _, d := range d.Disks {
+			disk, err := workloads.ToMap(d)
+			if err != nil {
+				return err
+			}
+			disk["node"] = nodeID
+			disks = append(disks, disk)
+		}
+
+		for _, v := range d.Volumes {
+			volume, err := workloads.ToMap(v)
+			if err != nil {
+				return err
+			}
+			volume["node"] = nodeID
+			volumes = append(volumes, volume)
+		}
+
+		for _, z := range d.Zdbs {
+			zdb, err := workloads.ToMap(z)
+			if err != nil {
+				return err
+			}
+			zdb["node"] = nodeID
+			zdbs = append(zdbs, zdb)
 		}
 
-		qs["metadata"] = []interface{}{qs["metadata"]}
-		qsfs = append(qsfs, qs)
+		for _, q := range d.QSFS {
+			qs, err := workloads.ToMap(q)
+			if err != nil {
+				return err
+			}
+
+			qs["metadata"] = []interface{}{qs["metadata"]}
+			qs["node"] = nodeID
+			qsfs = append(qsfs, qs)
+		}
+
+		if d.NodeID == defaultNode {
+			// the network might not be in the local state, so fall back to the subnet of the deployed vms
+			ipRange = d.IPrange
+			if ipRange == "" {
+				ipRange = vmsIPRange(d)
+			}
+		}
 	}
 
-	err := r.Set("vms", vms)
+	placeWorkloads(vms, r.Get("vms").([]interface{}), defaultNode)
+	err := r.Set("vms", mergeConfiguredVMs(r.Get("vms").([]interface{}), vms))
 	if err != nil {
 		errors = multierror.Append(errors, fmt.Errorf("failed to set vms with error: %w", err))
 	}
 
+	placeWorkloads(zdbs, r.Get("zdbs").([]interface{}), defaultNode)
 	err = r.Set("zdbs", zdbs)
 	if err != nil {
 		errors = multierror.Append(errors, fmt.Errorf("failed to set zdbs with error: %w", err))
 	}
 
+	placeWorkloads(disks, r.Get("disks").([]interface{}), defaultNode)
 	err = r.Set("disks", disks)
 	if err != nil {
 		errors = multierror.Append(errors, fmt.Errorf("failed to set disks with error: %w", err))
 	}
 
+	placeWorkloads(volumes, r.Get("volumes").([]interface{}), defaultNode)
+	err = r.Set("volumes", volumes)
+	if err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("failed to set volumes with error: %w", err))
+	}
+
+	placeWorkloads(qsfs, r.Get("qsfs").([]interface{}), defaultNode)
 	err = r.Set("qsfs", qsfs)
 	if err != nil {
 		errors = multierror.Append(errors, fmt.Errorf("failed to set qsfs with error: %w", err))
 	}
 
-	err = r.Set("node", d.NodeID)
+	err = r.Set("node", defaultNode)
 	if err != nil {
 		errors = multierror.Append(errors, fmt.Errorf("failed to set node with error: %w", err))
 	}
 
-	err = r.Set("network_name", d.NetworkName)
-	if err != nil {
-		errors = multierror.Append(errors, fmt.Errorf("failed to set network name with error: %w", err))
+	if len(dls) != 0 {
+		d := dls[0]
+		err = r.Set("network_name", d.NetworkName)
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set network name with error: %w", err))
+		}
+
+		err = r.Set("solution_type", d.SolutionType)
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set solution type with error: %w", err))
+		}
+
+		var solutionProvider int
+		if d.SolutionProvider != nil {
+			solutionProvider = int(*d.SolutionProvider)
+		}
+		err = r.Set("solution_provider", solutionProvider)
+		if err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set solution provider with error: %w", err))
+		}
 	}
 
-	err = r.Set("solution_type", d.SolutionType)
+	err = r.Set("ip_range", ipRange)
 	if err != nil {
-		errors = multierror.Append(errors, fmt.Errorf("failed to set solution type with error: %w", err))
+		errors = multierror.Append(errors, fmt.Errorf("failed to set ip range with error: %w", err))
 	}
 
-	var solutionProvider int
-	if d.SolutionProvider != nil {
-		solutionProvider = int(*d.SolutionProvider)
+	if err := trackDeploymentContracts(r, dls, defaultNode); err != nil {
+		errors = multierror.Append(errors, err)
 	}
-	err = r.Set("solution_provider", solutionProvider)
-	if err != nil {
-		errors = multierror.Append(errors, fmt.Errorf("failed to set solution provider with error: %w", err))
+	return
+}
+
+// trackDeploymentContracts stores the contracts of the deployments and the resource id, which is the contract on the
+// deployment node if it has one. It's done before anything else when an apply fails, so the contracts left on the
+// nodes aren't orphaned and the tainted resource cleans them up
+func trackDeploymentContracts(r *schema.ResourceData, dls []*workloads.Deployment, defaultNode uint32) error {
+	contracts := make(map[uint32]uint64)
+	var contractID uint64
+	for _, dl := range dls {
+		if dl.ContractID != 0 {
+			contracts[dl.NodeID] = dl.ContractID
+		}
+		if dl.NodeID == defaultNode || contractID == 0 {
+			contractID = dl.ContractID
+		}
 	}
 
-	/* TODO: iprange
-	err = r.Set("ip_range", d.IPRange)
+	if err := r.Set("node_deployment_id", nodeDeploymentIDMap(contracts)); err != nil {
+		return fmt.Errorf("failed to set node deployment id with error: %w", err)
+	}
+	r.SetId(fmt.Sprint(contractID))
+	return nil
+}
+
+// keepNodeContracts tracks the contracts of the nodes left by all the workloads along with the deployments ones, so
+// the contracts that aren't canceled yet are canceled by the next apply instead of being orphaned
+func keepNodeContracts(r *schema.ResourceData, kept map[uint32]uint64) error {
+	if len(kept) == 0 {
+		return nil
+	}
+	contracts, err := nodeDeploymentIDs(r)
 	if err != nil {
-		errors = multierror.Append(errors, fmt.Errorf("failed to set ip range with error: %w", err))
+		return err
 	}
-	*/
+	for node, contractID := range kept {
+		if _, ok := contracts[node]; !ok {
+			contracts[node] = contractID
+		}
+	}
+	if err := r.Set("node_deployment_id", nodeDeploymentIDMap(contracts)); err != nil {
+		return fmt.Errorf("failed to set node deployment id with error: %w", err)
+	}
+	return nil
+}
 
-	r.SetId(fmt.Sprint(d.ContractID))
-	return
+// placeWorkloads sets the node of the synced workloads the way they are configured, workloads on the deployment node
+// keep an unset node unless they are configured on another node
+func placeWorkloads(synced []interface{}, configured []interface{}, defaultNode uint32) {
+	configuredNodes := make(map[string]int)
+	for _, w := range configured {
+		wMap := w.(map[string]interface{})
+		node, _ := wMap["node"].(int)
+		configuredNodes[wMap["name"].(string)] = node
+	}
+
+	for _, w := range synced {
+		wMap := w.(map[string]interface{})
+		node, _ := wMap["node"].(int)
+		configuredNode := configuredNodes[wMap["name"].(string)]
+		if workloadNode(map[string]interface{}{"node": configuredNode}, defaultNode) == uint32(node) {
+			wMap["node"] = configuredNode
+		}
+	}
 }
@@ -0,0 +1,230 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func TestParseDeploymentImportID(t *testing.T) {
+	nodeID, contractID, err := parseDeploymentImportID("1234")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), nodeID)
+	assert.Equal(t, uint64(1234), contractID)
+
+	nodeID, contractID, err = parseDeploymentImportID("11:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(11), nodeID)
+	assert.Equal(t, uint64(1234), contractID)
+
+	for _, id := range []string{"", "abc", "11:", ":1234", "11:1234:5", "-1:1234"} {
+		_, _, err := parseDeploymentImportID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestCheckDeploymentHealth(t *testing.T) {
+	expected := []string{"vm1", "disk1", "zdb1"}
+	dl := &workloads.Deployment{
+		ContractID: 1,
+		Vms:        []workloads.VM{{Name: "vm1"}},
+		Disks:      []workloads.Disk{{Name: "disk1"}},
+		Zdbs:       []workloads.ZDB{{Name: "zdb1"}},
+	}
+	assert.NoError(t, checkDeploymentHealth(expected, dl))
+
+	dl.Zdbs = nil
+	assert.Error(t, checkDeploymentHealth(expected, dl), "zdb1 is not in an ok state")
+
+	dl.ContractID = 0
+	assert.Error(t, checkDeploymentHealth(nil, dl), "contract doesn't exist")
+}
+
+func TestValidateDuration(t *testing.T) {
+	for _, v := range []string{"", "10m", "1h30m", "0s"} {
+		_, errs := validateDuration(v, "migration_cutover_wait")
+		assert.Empty(t, errs, v)
+	}
+	for _, v := range []string{"10", "ten minutes", "-5m"} {
+		_, errs := validateDuration(v, "migration_cutover_wait")
+		assert.NotEmpty(t, errs, v)
+	}
+}
+
+func TestVMsIPRange(t *testing.T) {
+	dl := &workloads.Deployment{}
+	assert.Equal(t, "", vmsIPRange(dl))
+
+	dl.VmsLight = []workloads.VMLight{{Name: "vm1", IP: "10.1.3.2"}}
+	assert.Equal(t, "10.1.3.0/24", vmsIPRange(dl))
+}
+
+func TestValidateVMsIP(t *testing.T) {
+	dl := &workloads.Deployment{
+		IPrange: "10.1.3.0/24",
+		Vms:     []workloads.VM{{Name: "vm1", IP: "10.1.3.5"}, {Name: "vm2"}},
+	}
+	assert.NoError(t, validateVMsIP(dl))
+
+	for _, ip := range []string{"10.1.4.5", "10.1.3.1", "10.1.3.255", "invalid"} {
+		dl.Vms[0].IP = ip
+		assert.Error(t, validateVMsIP(dl), ip)
+	}
+
+	dl.IPrange = ""
+	assert.NoError(t, validateVMsIP(dl), "ip range is not known yet")
+}
+
+func TestMergeConfiguredVMs(t *testing.T) {
+	configured := []interface{}{
+		map[string]interface{}{"name": "vm2", "ready_address": "1.1.1.2:22", "publicip_address": "185.206.122.33/24"},
+		map[string]interface{}{"name": "vm3"},
+	}
+	deployed := []interface{}{
+		map[string]interface{}{"name": "vm4"},
+		map[string]interface{}{"name": "vm2"},
+	}
+
+	vms := mergeConfiguredVMs(configured, deployed)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"name": "vm2", "wait_for": nil, "ready_address": "1.1.1.2:22",
+			"publicip_address": "185.206.122.33/24", "publicip_gateway": nil, "publicip_range": nil,
+			"secure_env_vars": map[string]interface{}{}, "ssh_keys": []interface{}{},
+		},
+		map[string]interface{}{"name": "vm4", "secure_env_vars": map[string]interface{}{}, "ssh_keys": []interface{}{}},
+	}, vms, "vm3 is not deployed, so it is dropped")
+}
+
+func TestDeploymentMountables(t *testing.T) {
+	named := func(names ...string) []interface{} {
+		workloads := make([]interface{}, 0)
+		for _, name := range names {
+			workloads = append(workloads, map[string]interface{}{"name": name})
+		}
+		return workloads
+	}
+
+	volumes := named("volume", "")
+	volumes[0].(map[string]interface{})["node"] = 2
+	mountables := deploymentMountables(named("disk"), volumes, named("qsfs"), 1)
+	assert.Equal(t, map[string]mountable{
+		"disk":   {typ: mountTypeDisk, node: 1},
+		"volume": {typ: mountTypeVolume, node: 2},
+		"qsfs":   {typ: mountTypeQSFS, node: 1},
+	}, mountables)
+}
+
+func TestValidateVMMounts(t *testing.T) {
+	mountables := map[string]mountable{
+		"disk":   {typ: mountTypeDisk, node: 1},
+		"volume": {typ: mountTypeVolume, node: 1},
+		"qsfs":   {typ: mountTypeQSFS, node: 1},
+		"remote": {typ: mountTypeVolume, node: 2},
+	}
+	vm := func(mounts ...string) []interface{} {
+		vmMounts := make([]interface{}, 0)
+		for _, mount := range mounts {
+			vmMounts = append(vmMounts, map[string]interface{}{"name": mount, "mount_point": "/" + mount})
+		}
+		return []interface{}{map[string]interface{}{"name": "vm", "mounts": vmMounts}}
+	}
+	light := map[uint32]bool{1: true}
+
+	assert.NoError(t, validateVMMounts(vm("disk", "volume", "qsfs"), mountables, 1, nil))
+	assert.NoError(t, validateVMMounts(vm("disk", "volume"), mountables, 1, light))
+	assert.Error(t, validateVMMounts(vm("qsfs"), mountables, 1, light), "light nodes don't serve qsfs")
+	assert.Error(t, validateVMMounts(vm("zdb"), mountables, 1, nil), "only disks, volumes and qsfss can be mounted")
+	assert.Error(t, validateVMMounts(append(vm("disk"), vm("disk")...), mountables, 1, nil), "a disk is only mounted by one vm")
+	assert.NoError(t, validateVMMounts(append(vm("volume"), vm("volume")...), mountables, 1, nil))
+	assert.Error(t, validateVMMounts(vm("remote"), mountables, 1, nil), "vms only mount workloads on their node")
+
+	remoteVM := vm("remote")
+	remoteVM[0].(map[string]interface{})["node"] = 2
+	assert.NoError(t, validateVMMounts(remoteVM, mountables, 1, nil))
+	assert.NoError(t, validateVMMounts(vm("remote"), mountables, 0, nil), "the vm node is not known yet")
+}
+
+func TestWorkloadNode(t *testing.T) {
+	assert.Equal(t, uint32(1), workloadNode(map[string]interface{}{"node": 0}, 1))
+	assert.Equal(t, uint32(1), workloadNode(map[string]interface{}{}, 1))
+	assert.Equal(t, uint32(2), workloadNode(map[string]interface{}{"node": 2}, 1))
+	assert.Equal(t, uint32(0), workloadNode(map[string]interface{}{"node": unknownNode}, 1))
+}
+
+func TestSortedDeployments(t *testing.T) {
+	dls := map[uint32]*workloads.Deployment{3: {NodeID: 3}, 7: {NodeID: 7}, 5: {NodeID: 5}}
+	nodes := make([]uint32, 0)
+	for _, dl := range sortedDeployments(dls, 5) {
+		nodes = append(nodes, dl.NodeID)
+	}
+	assert.Equal(t, []uint32{5, 3, 7}, nodes)
+}
+
+func TestPlaceWorkloads(t *testing.T) {
+	configured := []interface{}{
+		map[string]interface{}{"name": "vm1", "node": 0},
+		map[string]interface{}{"name": "vm2", "node": 2},
+		map[string]interface{}{"name": "vm3", "node": 0},
+	}
+	synced := []interface{}{
+		map[string]interface{}{"name": "vm1", "node": 1},
+		map[string]interface{}{"name": "vm2", "node": 2},
+		map[string]interface{}{"name": "vm3", "node": 3},
+		map[string]interface{}{"name": "vm4", "node": 1},
+	}
+
+	placeWorkloads(synced, configured, 1)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "vm1", "node": 0},
+		map[string]interface{}{"name": "vm2", "node": 2},
+		map[string]interface{}{"name": "vm3", "node": 3},
+		map[string]interface{}{"name": "vm4", "node": 0},
+	}, synced, "vm3 is not on the node it's configured on, so its actual node is kept")
+}
+
+func TestNodeDeploymentIDs(t *testing.T) {
+	raw := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{"node": 1})
+	raw.SetId("10")
+	d, err := schema.InternalMap(resourceDeployment().Schema).Data(raw.State(), nil)
+	assert.NoError(t, err)
+	contracts, err := nodeDeploymentIDs(d)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint32]uint64{1: 10}, contracts, "the id is the contract of the deployment node")
+
+	assert.NoError(t, d.Set("node_deployment_id", map[string]interface{}{"1": 10, "2": 11}))
+	contracts, err = nodeDeploymentIDs(d)
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint32]uint64{1: 10, 2: 11}, contracts)
+}
+
+func TestTrackDeploymentContracts(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{"node": 1})
+	dls := []*workloads.Deployment{{NodeID: 1}, {NodeID: 2, ContractID: 11}, {NodeID: 3, ContractID: 12}}
+
+	assert.NoError(t, trackDeploymentContracts(d, dls, 1))
+	assert.Equal(t, "11", d.Id(), "the deployment node has no contract, so the first contract is the id")
+	assert.Equal(t, map[string]interface{}{"2": 11, "3": 12}, d.Get("node_deployment_id"))
+
+	dls[0].ContractID = 10
+	assert.NoError(t, trackDeploymentContracts(d, dls, 1))
+	assert.Equal(t, "10", d.Id())
+}
+
+func TestKeepNodeContracts(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{"node": 1})
+	assert.NoError(t, trackDeploymentContracts(d, []*workloads.Deployment{{NodeID: 1, ContractID: 10}}, 1))
+
+	assert.NoError(t, keepNodeContracts(d, map[uint32]uint64{1: 5, 2: 11}))
+	assert.Equal(t, map[string]interface{}{"1": 10, "2": 11}, d.Get("node_deployment_id"), "the deployments contracts are kept")
+	assert.Equal(t, "10", d.Id())
+}
+
+func TestHasNodeContracts(t *testing.T) {
+	assert.False(t, hasNodeContracts(nil))
+	assert.False(t, hasNodeContracts(map[uint32]uint64{1: 0}))
+	assert.True(t, hasNodeContracts(map[uint32]uint64{1: 0, 2: 5}))
+}
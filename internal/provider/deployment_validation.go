@@ -0,0 +1,257 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+	"github.com/threefoldtech/zos/pkg/gridtypes/zos"
+)
+
+// deploymentWorkloadBlocks are the grid_deployment blocks holding workloads, their names have to be unique within the deployment
+var deploymentWorkloadBlocks = []string{"vms", "disks", "volumes", "zdbs", "qsfs"}
+
+// unknownNode marks the workloads whose node is not known yet at plan time
+const unknownNode = -1
+
+// resourceDeploymentCustomizeDiff reports all the configuration errors that the nodes would only report on apply,
+// the flists of the vms are listed from their hub, and the nodes are only asked whether they're zos light nodes if
+// their vms mount workloads that light nodes can't serve
+func resourceDeploymentCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	var errs *multierror.Error
+	errs = multierror.Append(errs, validateWorkloadNames(d))
+	errs = multierror.Append(errs, validateDeploymentVMs(d))
+	errs = multierror.Append(errs, validateQSFSShards(d))
+	errs = multierror.Append(errs, validateQSFSBackends(d))
+
+	var defaultNode uint32
+	if d.NewValueKnown("node") {
+		defaultNode = uint32(d.Get("node").(int))
+	}
+	placed := make(map[string][]interface{})
+	for _, block := range []string{"vms", "disks", "volumes", "qsfs"} {
+		placed[block] = d.Get(block).([]interface{})
+		for i, w := range placed[block] {
+			if !d.NewValueKnown(fmt.Sprintf("%s.%d.node", block, i)) {
+				w.(map[string]interface{})["node"] = unknownNode
+			}
+		}
+	}
+
+	mountables := deploymentMountables(placed["disks"], placed["volumes"], placed["qsfs"], defaultNode)
+	vms := placed["vms"]
+	errs = multierror.Append(errs, validateVMMounts(vms, mountables, defaultNode, nil))
+	if errs.ErrorOrNil() != nil {
+		return errs
+	}
+	if err := validateVMFlists(ctx, vms, placed["disks"]); err != nil {
+		return err
+	}
+
+	lightNodes := make(map[uint32]bool)
+	for _, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		mounts, _ := vmMap["mounts"].([]interface{})
+		if node := workloadNode(vmMap, defaultNode); node != 0 && len(mounts) != 0 {
+			lightNodes[node] = true
+		}
+	}
+	selections, err := vmsPublicIPSelections(knownPublicIPSelections(d, vms), defaultNode)
+	if err != nil {
+		return err
+	}
+	if validateVMMounts(vms, mountables, defaultNode, lightNodes) == nil && len(selections) == 0 {
+		return nil
+	}
+
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	for node := range lightNodes {
+		light, err := isZosLight(ctx, node, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+		if err != nil {
+			return err
+		}
+		lightNodes[node] = light
+	}
+	if err := validateVMMounts(vms, mountables, defaultNode, lightNodes); err != nil {
+		return err
+	}
+
+	if len(selections) == 0 {
+		return nil
+	}
+	contracts, err := nodeDeploymentIDs(d)
+	if err != nil {
+		return err
+	}
+	return validatePublicIPSelections(tfPluginClient.SubstrateConn, selections, vmsPublicIPCount(vms, defaultNode), contracts)
+}
+
+// knownPublicIPSelections returns the vms whose public ip selection is known at plan time
+func knownPublicIPSelections(d *schema.ResourceDiff, vms []interface{}) []interface{} {
+	known := make([]interface{}, 0, len(vms))
+	for i, vm := range vms {
+		path := fmt.Sprintf("vms.%d", i)
+		if !d.NewValueKnown(path+".publicip") || !d.NewValueKnown(path+".publicip_address") || !d.NewValueKnown(path+".publicip_gateway") || !d.NewValueKnown(path+".publicip_range") {
+			continue
+		}
+		known = append(known, vm)
+	}
+	return known
+}
+
+// validateWorkloadNames makes sure no two workloads of the deployment have the same name
+func validateWorkloadNames(d *schema.ResourceDiff) error {
+	var errs error
+	paths := make(map[string]string)
+	for _, block := range deploymentWorkloadBlocks {
+		for i, w := range d.Get(block).([]interface{}) {
+			name := w.(map[string]interface{})["name"].(string)
+			if name == "" {
+				// not known yet
+				continue
+			}
+
+			path := fmt.Sprintf("%s.%d.name", block, i)
+			if other, ok := paths[name]; ok {
+				errs = multierror.Append(errs, fmt.Errorf("%s: workload name %s is already used by %s", path, name, other))
+				continue
+			}
+			paths[name] = path
+		}
+	}
+	return errs
+}
+
+// validateDeploymentVMs checks the vm attributes that depend on each other or on the rest of the deployment
+func validateDeploymentVMs(d *schema.ResourceDiff) error {
+	var errs error
+	vms := d.Get("vms").([]interface{})
+
+	for i, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		path := fmt.Sprintf("vms.%d", i)
+
+		if seed := vmMap["mycelium_ip_seed"].(string); seed != "" {
+			if b, err := hex.DecodeString(seed); err != nil || len(b) != zos.MyceliumIPSeedLen {
+				errs = multierror.Append(errs, fmt.Errorf("%s.mycelium_ip_seed: must be %d hex encoded bytes (e.g. b60f2b7ec39c), got %s", path, zos.MyceliumIPSeedLen, seed))
+			}
+		}
+
+		cpu, memory, rootfs := vmMap["cpu"].(int), vmMap["memory"].(int), vmMap["rootfs_size"].(int)
+		if memory == 0 && d.NewValueKnown(path+".memory") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.memory: is required", path))
+		}
+		if memory != 0 && rootfs != 0 {
+			machine := zos.ZMachine{ComputeCapacity: zos.MachineCapacity{CPU: uint8(cpu), Memory: gridtypes.Unit(memory) * gridtypes.Megabyte}}
+			minRoot := machine.MinRootSize() / gridtypes.Megabyte
+			if gridtypes.Unit(rootfs) < minRoot {
+				errs = multierror.Append(errs, fmt.Errorf("%s.rootfs_size: must be at least %d MB for %d cpus and %d MB of memory, or unset for the minimum", path, minRoot, cpu, memory))
+			}
+		}
+
+		if vmMap["publicip_address"] != "" && (vmMap["publicip_gateway"] != "" || vmMap["publicip_range"] != "") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.publicip_address: can't be set together with publicip_gateway or publicip_range", path))
+		}
+		if vmMap["publicip"] != true && d.NewValueKnown(path+".publicip") {
+			for _, key := range []string{"publicip_address", "publicip_gateway", "publicip_range"} {
+				if vmMap[key] != "" {
+					errs = multierror.Append(errs, fmt.Errorf("%s.%s: requires publicip", path, key))
+				}
+			}
+		}
+
+		envVars, _ := vmMap["env_vars"].(map[string]interface{})
+		if _, ok := envVars[sshKeyEnv]; ok && len(vmMap["ssh_keys"].([]interface{})) != 0 {
+			errs = multierror.Append(errs, fmt.Errorf("%s.ssh_keys: can't be set together with the %s entry of env_vars", path, sshKeyEnv))
+		}
+
+		secureEnv, _ := vmMap["secure_env_vars"].(map[string]interface{})
+		secureKeys := make([]string, 0, len(secureEnv))
+		for key := range secureEnv {
+			secureKeys = append(secureKeys, key)
+		}
+		slices.Sort(secureKeys)
+		for _, key := range secureKeys {
+			if _, ok := envVars[key]; ok {
+				errs = multierror.Append(errs, fmt.Errorf("%s.secure_env_vars: %s is set in env_vars as well", path, key))
+			}
+		}
+		if _, ok := secureEnv[sshKeyEnv]; ok && len(vmMap["ssh_keys"].([]interface{})) != 0 {
+			errs = multierror.Append(errs, fmt.Errorf("%s.ssh_keys: can't be set together with the %s entry of secure_env_vars", path, sshKeyEnv))
+		}
+	}
+
+	if len(vms) != 0 && d.Get("network_name") == "" && d.NewValueKnown("network_name") {
+		errs = multierror.Append(errs, fmt.Errorf("network_name: is required by the vms for their private, planetary and mycelium interfaces"))
+	}
+	return errs
+}
+
+// validateQSFSShards makes sure the qsfs workloads could recover their data
+func validateQSFSShards(d *schema.ResourceDiff) error {
+	var errs error
+	for i, q := range d.Get("qsfs").([]interface{}) {
+		qMap := q.(map[string]interface{})
+		minimal, expected := qMap["minimal_shards"].(int), qMap["expected_shards"].(int)
+		if expected != 0 && minimal > expected {
+			errs = multierror.Append(errs, fmt.Errorf("qsfs.%d.minimal_shards: %d is more than the %d expected shards", i, minimal, expected))
+		}
+	}
+	return errs
+}
+
+// validateQSFSBackends makes sure the qsfs workloads either give their backends and keys, or let zdb_backends deploy
+// the backends and fill the keys that aren't given
+func validateQSFSBackends(d *schema.ResourceDiff) error {
+	var errs error
+	for i, q := range d.Get("qsfs").([]interface{}) {
+		qMap := q.(map[string]interface{})
+		path := fmt.Sprintf("qsfs.%d", i)
+		metadata, _ := qMap["metadata"].([]interface{})
+		groups, _ := qMap["groups"].([]interface{})
+
+		if config := zdbBackendsConfig(qMap); config != nil {
+			if len(groups) != 0 {
+				errs = multierror.Append(errs, fmt.Errorf("%s.groups: can't be set together with zdb_backends", path))
+			}
+			if len(metadata) != 0 && metadata[0] != nil {
+				if backends, _ := metadata[0].(map[string]interface{})["backends"].([]interface{}); len(backends) != 0 {
+					errs = multierror.Append(errs, fmt.Errorf("%s.metadata.0.backends: can't be set together with zdb_backends", path))
+				}
+			}
+			nodes := make(map[int]bool)
+			for _, node := range config["nodes"].([]interface{}) {
+				nodeID, _ := node.(int)
+				if nodeID != 0 && nodes[nodeID] {
+					errs = multierror.Append(errs, fmt.Errorf("%s.zdb_backends.0.nodes: node %d is listed twice", path, nodeID))
+				}
+				nodes[nodeID] = true
+			}
+			continue
+		}
+
+		if qMap["encryption_key"] == "" && d.NewValueKnown(path+".encryption_key") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.encryption_key: is required unless zdb_backends is set", path))
+		}
+		if len(metadata) == 0 && d.NewValueKnown(path+".metadata") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.metadata: is required unless zdb_backends is set", path))
+		}
+		if len(metadata) != 0 && metadata[0] != nil && metadata[0].(map[string]interface{})["encryption_key"] == "" && d.NewValueKnown(path+".metadata.0.encryption_key") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.metadata.0.encryption_key: is required unless zdb_backends is set", path))
+		}
+		if len(groups) == 0 && d.NewValueKnown(path+".groups") {
+			errs = multierror.Append(errs, fmt.Errorf("%s.groups: is required unless zdb_backends is set", path))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,176 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func TestResourceDeploymentCustomizeDiff(t *testing.T) {
+	imageSizes := map[string]uint64{"https://hub.grid.tf/tf-official-vms/ubuntu-22.04.flist": 2*uint64(gridtypes.Gigabyte) + 1}
+	flistImageSize = func(ctx context.Context, flist string) (uint64, error) {
+		return imageSizes[flist], nil
+	}
+	t.Cleanup(func() { flistImageSize = hubFlistImageSize })
+
+	diff := func(raw map[string]interface{}) error {
+		_, err := resourceDeployment().Diff(context.Background(), nil, terraform.NewResourceConfigRaw(raw), nil)
+		return err
+	}
+	vm := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":   name,
+			"flist":  "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"memory": 1024,
+			"mounts": []interface{}{map[string]interface{}{"name": "data", "mount_point": "/data"}},
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		err := diff(map[string]interface{}{
+			"node":         1,
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "data", "size": 10}},
+			"vms":          []interface{}{vm("vm1")},
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("mounts on another node", func(t *testing.T) {
+		err := diff(map[string]interface{}{
+			"node":         1,
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "data", "size": 10, "node": 2}},
+			"vms":          []interface{}{vm("vm1")},
+		})
+		assert.ErrorContains(t, err, "vms.0.mounts.0.name: disk data is on node 2, not on the vm node 1")
+	})
+
+	t.Run("qsfs backends", func(t *testing.T) {
+		qsfs := func(extra map[string]interface{}) map[string]interface{} {
+			q := map[string]interface{}{
+				"name":                  "qsfs",
+				"cache":                 1024,
+				"minimal_shards":        2,
+				"expected_shards":       4,
+				"redundant_groups":      0,
+				"redundant_nodes":       0,
+				"max_zdb_data_dir_size": 512,
+			}
+			for k, v := range extra {
+				q[k] = v
+			}
+			return q
+		}
+		zdbBackends := []interface{}{map[string]interface{}{"nodes": []interface{}{11, 12}, "data_zdb_size": 10}}
+
+		err := diff(map[string]interface{}{
+			"node": 1,
+			"qsfs": []interface{}{qsfs(map[string]interface{}{"zdb_backends": zdbBackends})},
+		})
+		assert.NoError(t, err)
+
+		err = diff(map[string]interface{}{
+			"node": 1,
+			"qsfs": []interface{}{qsfs(nil)},
+		})
+		assert.ErrorContains(t, err, "qsfs.0.encryption_key: is required unless zdb_backends is set")
+		assert.ErrorContains(t, err, "qsfs.0.metadata: is required unless zdb_backends is set")
+		assert.ErrorContains(t, err, "qsfs.0.groups: is required unless zdb_backends is set")
+
+		err = diff(map[string]interface{}{
+			"node": 1,
+			"qsfs": []interface{}{qsfs(map[string]interface{}{
+				"zdb_backends": []interface{}{map[string]interface{}{"nodes": []interface{}{11, 11}, "data_zdb_size": 10}},
+				"groups":       []interface{}{map[string]interface{}{"backends": []interface{}{map[string]interface{}{"address": "[301::1]:9900", "namespace": "ns", "password": "pass"}}}},
+			})},
+		})
+		assert.ErrorContains(t, err, "qsfs.0.groups: can't be set together with zdb_backends")
+		assert.ErrorContains(t, err, "qsfs.0.zdb_backends.0.nodes: node 11 is listed twice")
+	})
+
+	t.Run("public ip selection", func(t *testing.T) {
+		selecting := vm("vm1")
+		selecting["publicip_address"] = "185.206.122.33/24"
+		selecting["publicip_gateway"] = "185.206.122.1"
+
+		err := diff(map[string]interface{}{
+			"node":         1,
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "data", "size": 10}},
+			"vms":          []interface{}{selecting},
+		})
+		assert.ErrorContains(t, err, "vms.0.publicip_address: can't be set together with publicip_gateway or publicip_range")
+		assert.ErrorContains(t, err, "vms.0.publicip_address: requires publicip")
+		assert.ErrorContains(t, err, "vms.0.publicip_gateway: requires publicip")
+	})
+
+	t.Run("secure env vars", func(t *testing.T) {
+		secure := vm("vm1")
+		secure["env_vars"] = map[string]interface{}{"TOKEN": "plain"}
+		secure["secure_env_vars"] = map[string]interface{}{"TOKEN": "secret", sshKeyEnv: testSSHKey1}
+		secure["ssh_keys"] = []interface{}{testSSHKey2}
+
+		err := diff(map[string]interface{}{
+			"node":         1,
+			"network_name": "net",
+			"disks":        []interface{}{map[string]interface{}{"name": "data", "size": 10}},
+			"vms":          []interface{}{secure},
+		})
+		assert.ErrorContains(t, err, "vms.0.secure_env_vars: TOKEN is set in env_vars as well")
+		assert.ErrorContains(t, err, "vms.0.ssh_keys: can't be set together with the SSH_KEY entry of secure_env_vars")
+	})
+
+	t.Run("full vm flist", func(t *testing.T) {
+		full := vm("vm1")
+		full["flist"] = "https://hub.grid.tf/tf-official-vms/ubuntu-22.04.flist"
+		config := func(size int) map[string]interface{} {
+			return map[string]interface{}{
+				"node":         1,
+				"network_name": "net",
+				"disks":        []interface{}{map[string]interface{}{"name": "data", "size": size}},
+				"vms":          []interface{}{full},
+			}
+		}
+
+		assert.NoError(t, diff(config(3)))
+		assert.ErrorContains(t, diff(config(2)), "vms.0.mounts.0.name: disk data of 2 GB is smaller than the 2049 MB image of flist https://hub.grid.tf/tf-official-vms/ubuntu-22.04.flist")
+
+		full["mounts"] = nil
+		assert.ErrorContains(t, diff(config(3)), "vms.0.mounts: flist https://hub.grid.tf/tf-official-vms/ubuntu-22.04.flist is a full vm image of 2049 MB")
+	})
+
+	t.Run("all problems are reported", func(t *testing.T) {
+		invalid := vm("data")
+		invalid["mycelium_ip_seed"] = "b60f2b"
+		invalid["rootfs_size"] = 1024
+		invalid["cpu"] = 8
+		invalid["memory"] = 8 * 1024
+		invalid["mounts"] = []interface{}{map[string]interface{}{"name": "missing", "mount_point": "/data"}}
+
+		err := diff(map[string]interface{}{
+			"node":  1,
+			"disks": []interface{}{map[string]interface{}{"name": "data", "size": 10}},
+			"vms":   []interface{}{invalid},
+		})
+		assert.Error(t, err)
+
+		merr, ok := err.(*multierror.Error)
+		assert.True(t, ok)
+		messages := make([]string, 0)
+		for _, e := range merr.Errors {
+			messages = append(messages, e.Error())
+		}
+		assert.Len(t, messages, 5)
+		assert.Contains(t, messages, "disks.0.name: workload name data is already used by vms.0.name")
+		assert.Contains(t, messages, "vms.0.mycelium_ip_seed: must be 6 hex encoded bytes (e.g. b60f2b7ec39c), got b60f2b")
+		assert.Contains(t, messages, "vms.0.rootfs_size: must be at least 2048 MB for 8 cpus and 8192 MB of memory, or unset for the minimum")
+		assert.Contains(t, messages, "network_name: is required by the vms for their private, planetary and mycelium interfaces")
+		assert.Contains(t, messages, "vms.0.mounts.0.name: missing is not a disk, volume or qsfs of the deployment")
+	})
+}
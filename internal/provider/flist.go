@@ -0,0 +1,118 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// flistImagePath is the disk image of a full vm flist, zos writes it to the first disk mounted on the vm and boots
+// from it. Flists without it are booted as containers on the vm rootfs
+const flistImagePath = "/image.raw"
+
+// flistListing is the part of the listing of a flist by its hub used to validate vms
+type flistListing struct {
+	Content []struct {
+		Path string `json:"path"`
+		Size uint64 `json:"size"`
+	} `json:"content"`
+}
+
+// flistImageSize returns the size of the disk image of a full vm flist in bytes, zero for container flists.
+// It's a variable so tests don't reach the hub
+var flistImageSize = hubFlistImageSize
+
+// hubFlistImageSize reads the disk image size of a flist from the listing of the hub serving it
+func hubFlistImageSize(ctx context.Context, flist string) (uint64, error) {
+	u, err := url.Parse(flist)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldn't parse flist url '%s'", flist)
+	}
+	repository, name := path.Split(strings.TrimPrefix(u.Path, "/"))
+	repository = strings.TrimSuffix(repository, "/")
+	if repository == "" || strings.Contains(repository, "/") || !strings.HasSuffix(name, ".flist") {
+		return 0, fmt.Errorf("flist '%s' is not served by a hub", flist)
+	}
+
+	listingURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path.Join("/api/flist", repository, name)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listingURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldn't list flist '%s'", flist)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("couldn't list flist '%s', the hub responded with status %d", flist, resp.StatusCode)
+	}
+
+	var listing flistListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return 0, errors.Wrapf(err, "couldn't decode listing of flist '%s'", flist)
+	}
+	for _, entry := range listing.Content {
+		if entry.Path == flistImagePath {
+			return entry.Size, nil
+		}
+	}
+	return 0, nil
+}
+
+// validateVMFlists makes sure the first mount of each vm booting a full vm flist is a disk that fits the flist image.
+// The flists that can't be listed are left for the nodes to check on apply
+func validateVMFlists(ctx context.Context, vms []interface{}, disks []interface{}) (errs error) {
+	diskSizes := make(map[string]int)
+	for _, disk := range disks {
+		diskMap := disk.(map[string]interface{})
+		diskSizes[diskMap["name"].(string)] = diskMap["size"].(int)
+	}
+
+	for i, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		flist := vmMap["flist"].(string)
+		if flist == "" {
+			// not known yet
+			continue
+		}
+		imageSize, err := flistImageSize(ctx, flist)
+		if err != nil {
+			log.Printf("couldn't check the image size of flist %s: %s", flist, err)
+			continue
+		}
+		if imageSize == 0 {
+			continue
+		}
+
+		path := fmt.Sprintf("vms.%d", i)
+		imageMB := (imageSize + uint64(gridtypes.Megabyte) - 1) / uint64(gridtypes.Megabyte)
+		mounts, _ := vmMap["mounts"].([]interface{})
+		if len(mounts) == 0 || mounts[0] == nil {
+			errs = multierror.Append(errs, fmt.Errorf("%s.mounts: flist %s is a full vm image of %d MB, it's written to the first mount, which must be a disk at least as large", path, flist, imageMB))
+			continue
+		}
+		name := mounts[0].(map[string]interface{})["name"].(string)
+		size, ok := diskSizes[name]
+		if !ok {
+			if name != "" {
+				errs = multierror.Append(errs, fmt.Errorf("%s.mounts.0.name: flist %s is a full vm image, it's written to the first mount, which must be a disk, not %s", path, flist, name))
+			}
+			continue
+		}
+		if uint64(size)*uint64(gridtypes.Gigabyte) < imageSize {
+			errs = multierror.Append(errs, fmt.Errorf("%s.mounts.0.name: disk %s of %d GB is smaller than the %d MB image of flist %s", path, name, size, imageMB, flist))
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubFlistImageSize(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/flist/tf-official-vms/ubuntu-22.04.flist":
+			_, _ = w.Write([]byte(`{"content": [{"path": "/", "size": 4096}, {"path": "/image.raw", "size": 2147483648}]}`))
+		case "/api/flist/tf-official-apps/base:latest.flist":
+			_, _ = w.Write([]byte(`{"content": [{"path": "/sbin/zinit", "size": 4096}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer hub.Close()
+
+	size, err := hubFlistImageSize(context.Background(), hub.URL+"/tf-official-vms/ubuntu-22.04.flist")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2147483648), size)
+
+	size, err = hubFlistImageSize(context.Background(), hub.URL+"/tf-official-apps/base:latest.flist")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), size, "container flists have no image")
+
+	_, err = hubFlistImageSize(context.Background(), hub.URL+"/tf-official-apps/missing.flist")
+	assert.Error(t, err)
+
+	_, err = hubFlistImageSize(context.Background(), hub.URL+"/flists/base.tar.gz")
+	assert.Error(t, err, "not a hub flist")
+}
@@ -49,6 +49,14 @@ func newNameGatewayFromSchema(d *schema.ResourceData) (*workloads.GatewayNamePro
 		solutionType = d.Get("name").(string)
 	}
 
+	nameContractID := uint64(d.Get("name_contract_id").(int))
+	if reserved := uint64(d.Get("name_contract").(int)); reserved != 0 {
+		nameContractID = reserved
+	} else if oldReserved, _ := d.GetChange("name_contract"); oldReserved.(int) != 0 {
+		// the gateway stops using the reserved name, it gets a name contract of its own
+		nameContractID = 0
+	}
+
 	gw := workloads.GatewayNameProxy{
 		NodeID:           uint32(d.Get("node").(int)),
 		Name:             d.Get("name").(string),
@@ -59,7 +67,7 @@ func newNameGatewayFromSchema(d *schema.ResourceData) (*workloads.GatewayNamePro
 		Network:          d.Get("network").(string),
 		FQDN:             d.Get("fqdn").(string),
 		NodeDeploymentID: nodeDeploymentID,
-		NameContractID:   uint64(d.Get("name_contract_id").(int)),
+		NameContractID:   nameContractID,
 		ContractID:       contractID,
 	}
 	return &gw, nil
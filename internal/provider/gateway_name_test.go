@@ -0,0 +1,39 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNameGatewayFromSchemaNameContract(t *testing.T) {
+	config := func(nameContract int) map[string]interface{} {
+		return map[string]interface{}{
+			"name":          "hamada",
+			"node":          11,
+			"backends":      []interface{}{"http://1.1.1.1:9000"},
+			"name_contract": nameContract,
+		}
+	}
+
+	t.Run("own name contract", func(t *testing.T) {
+		d := schema.TestResourceDataRaw(t, resourceGatewayNameProxy().Schema, config(0))
+		assert.NoError(t, d.Set("name_contract_id", 20))
+
+		gw, err := newNameGatewayFromSchema(d)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(20), gw.NameContractID)
+		assert.Equal(t, uint64(20), ownedNameContract(d))
+	})
+
+	t.Run("reserved name contract", func(t *testing.T) {
+		d := schema.TestResourceDataRaw(t, resourceGatewayNameProxy().Schema, config(30))
+		assert.NoError(t, d.Set("name_contract_id", 20))
+
+		gw, err := newNameGatewayFromSchema(d)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(30), gw.NameContractID)
+	})
+}
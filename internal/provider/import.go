@@ -0,0 +1,73 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+const (
+	networkImportPrefix = "network"
+	k8sImportPrefix     = "k8s"
+
+	// networkLightType is the deployment type in the metadata of network light contracts
+	networkLightType = "network-light"
+)
+
+// parseNamedImportID parses an import id of the form `<prefix>:<name>`
+func parseNamedImportID(id, prefix string) (string, error) {
+	p, name, ok := strings.Cut(id, ":")
+	if !ok || p != prefix || name == "" {
+		return "", fmt.Errorf("invalid import id '%s', expected '%s:<name>'", id, prefix)
+	}
+	return name, nil
+}
+
+// twinContracts lists the active node contracts of the twin, keyed by their deployment type and name
+func twinContracts(tfPluginClient *deployer.TFPluginClient) (map[string]map[string]map[uint32][]uint64, error) {
+	contracts, err := tfPluginClient.ContractsGetter.ListContractsByTwinID([]string{"Created, GracePeriod"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't list contracts of twin %d", tfPluginClient.TwinID)
+	}
+
+	named := map[string]map[string]map[uint32][]uint64{}
+	for _, contract := range contracts.NodeContracts {
+		metadata, err := workloads.ParseDeploymentData(contract.DeploymentData)
+		if err != nil {
+			// contracts created by other tools might not have valid metadata
+			continue
+		}
+
+		contractID, err := strconv.ParseUint(contract.ContractID, 0, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse contract id '%s'", contract.ContractID)
+		}
+
+		if _, ok := named[metadata.Type]; !ok {
+			named[metadata.Type] = map[string]map[uint32][]uint64{}
+		}
+		if _, ok := named[metadata.Type][metadata.Name]; !ok {
+			named[metadata.Type][metadata.Name] = map[uint32][]uint64{}
+		}
+		named[metadata.Type][metadata.Name][contract.NodeID] = append(named[metadata.Type][metadata.Name][contract.NodeID], contractID)
+	}
+
+	return named, nil
+}
+
+// newImportState creates a grid state holding only the given node contracts
+func newImportState(tfPluginClient *deployer.TFPluginClient, contracts ...map[uint32][]uint64) *state.State {
+	st := state.NewState(tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	for _, nodeContracts := range contracts {
+		for node, ids := range nodeContracts {
+			st.StoreContractIDs(node, ids...)
+		}
+	}
+	return st
+}
@@ -0,0 +1,32 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+)
+
+func TestParseNamedImportID(t *testing.T) {
+	name, err := parseNamedImportID("network:net1", networkImportPrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, "net1", name)
+
+	name, err = parseNamedImportID("k8s:cluster1", k8sImportPrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster1", name)
+
+	for _, id := range []string{"", "net1", "network:", "k8s:net1", "1234"} {
+		_, err := parseNamedImportID(id, networkImportPrefix)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestNetworkIPRange(t *testing.T) {
+	subnet, err := zos.ParseIPNet("10.20.3.0/24")
+	assert.NoError(t, err)
+
+	ipRange := networkIPRange(map[uint32]zos.IPNet{11: subnet})
+	assert.Equal(t, "10.20.0.0/16", ipRange.String())
+}
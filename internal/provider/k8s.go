@@ -72,11 +72,20 @@ func newK8sFromSchema(d *schema.ResourceData) (*workloads.K8sCluster, error) {
 		solutionType = fmt.Sprintf("kubernetes/%s", master.Name)
 	}
 
+	sshKey := d.Get("ssh_key").(string)
+	if sshKeys := d.Get("ssh_keys").([]interface{}); len(sshKeys) != 0 {
+		keys := []string{sshKey}
+		for _, key := range sshKeys {
+			keys = append(keys, key.(string))
+		}
+		sshKey = joinSSHKeys(keys...)
+	}
+
 	k8s := workloads.K8sCluster{
 		Master:           master,
 		Workers:          workers,
 		Token:            d.Get("token").(string),
-		SSHKey:           d.Get("ssh_key").(string),
+		SSHKey:           sshKey,
 		Flist:            d.Get("flist").(string),
 		FlistChecksum:    d.Get("flist_checksum").(string),
 		Entrypoint:       d.Get("entrypoint").(string),
@@ -155,7 +164,13 @@ func storeK8sState(d *schema.ResourceData, k8s *workloads.K8sCluster) (errors er
 		errors = multierror.Append(errors, err)
 	}
 
-	err = d.Set("ssh_key", k8s.SSHKey)
+	sshKey, sshKeys := splitClusterSSHKeys(k8s.SSHKey, d.Get("ssh_key").(string), d.Get("ssh_keys").([]interface{}))
+	err = d.Set("ssh_key", sshKey)
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	err = d.Set("ssh_keys", sshKeys)
 	if err != nil {
 		errors = multierror.Append(errors, err)
 	}
@@ -192,3 +207,16 @@ func removeExtraFieldsFromK8sNode(k8sNode map[string]interface{}) {
 	delete(k8sNode, "description")
 	delete(k8sNode, "env_vars")
 }
+
+// k8sNodes returns the nodes of the master and the workers of a cluster
+func k8sNodes(master, workers interface{}) []uint32 {
+	nodes := make([]uint32, 0)
+	for _, list := range []interface{}{master, workers} {
+		for _, node := range list.([]interface{}) {
+			if nodeMap, ok := node.(map[string]interface{}); ok {
+				nodes = append(nodes, uint32(nodeMap["node"].(int)))
+			}
+		}
+	}
+	return nodes
+}
@@ -79,20 +79,32 @@ func New(version string, st state.Getter) (func() *schema.Provider, subi.Substra
 					Description: "timeout duration in seconds for rmb calls",
 					DefaultFunc: schema.EnvDefaultFunc("RMB_TIMEOUT", 10),
 				},
+				"protect_all": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Description: "default deletion_protection of the resources that support it, a resource setting it explicitly overrides this default",
+					DefaultFunc: schema.EnvDefaultFunc("PROTECT_ALL", false),
+				},
 			},
 			DataSourcesMap: map[string]*schema.Resource{
 				"grid_gateway_domain": dataSourceGatewayDomain(),
 			},
 			ResourcesMap: map[string]*schema.Resource{
-				"grid_scheduler":  resourceScheduler(),
-				"grid_deployment": resourceDeployment(),
-				"grid_network":    resourceNetwork(),
-				"grid_kubernetes": resourceKubernetes(),
-				"grid_name_proxy": resourceGatewayNameProxy(),
-				"grid_fqdn_proxy": resourceGatewayFQDNProxy(),
+				"grid_scheduler":     resourceScheduler(),
+				"grid_deployment":    resourceDeployment(),
+				"grid_network":       resourceNetwork(),
+				"grid_kubernetes":    resourceKubernetes(),
+				"grid_name_proxy":    resourceGatewayNameProxy(),
+				"grid_fqdn_proxy":    resourceGatewayFQDNProxy(),
+				"grid_zdb":           resourceZDB(),
+				"grid_rent_contract": resourceRentContract(),
+				"grid_name_contract": resourceNameContract(),
 			},
 		}
-		configFunc, sub := providerConfigure(st)
+		protection := &deletionProtection{}
+		protection.protect(p.ResourcesMap)
+
+		configFunc, sub := providerConfigure(st, protection)
 		substrateConnection = sub
 		p.ConfigureContextFunc = configFunc
 
@@ -100,7 +112,7 @@ func New(version string, st state.Getter) (func() *schema.Provider, subi.Substra
 	}, substrateConnection
 }
 
-func providerConfigure(st state.Getter) (func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics), subi.SubstrateExt) {
+func providerConfigure(st state.Getter, protection *deletionProtection) (func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics), subi.SubstrateExt) {
 	var substrateConn subi.SubstrateExt
 	return func(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 		mnemonic := d.Get("mnemonic").(string)
@@ -110,6 +122,7 @@ func providerConfigure(st state.Getter) (func(ctx context.Context, d *schema.Res
 		relayURL := d.Get("relay_url").(string)
 		proxyURL := d.Get("proxy_url").(string)
 		timeout := d.Get("rmb_timeout").(int)
+		protection.protectAll = d.Get("protect_all").(bool)
 		debug := false
 
 		opts := []deployer.PluginOpt{
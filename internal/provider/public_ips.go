@@ -0,0 +1,310 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// farmSubstrate is the part of the substrate client that reads the public ips of the farm of a node
+type farmSubstrate interface {
+	GetNode(id uint32) (*substrate.Node, error)
+	GetFarm(id uint32) (*substrate.Farm, error)
+}
+
+// publicIPSelection is the public ipv4 a vm asks for among the public ips of the farm of its node
+type publicIPSelection struct {
+	address  net.IP
+	gateway  net.IP
+	ipRange  *net.IPNet
+	settings []string
+}
+
+// newPublicIPSelection reads the public ip selection of a vm, it's nil if the vm takes any public ip
+func newPublicIPSelection(vm map[string]interface{}) (*publicIPSelection, error) {
+	address, _ := vm["publicip_address"].(string)
+	gateway, _ := vm["publicip_gateway"].(string)
+	ipRange, _ := vm["publicip_range"].(string)
+	if address == "" && gateway == "" && ipRange == "" {
+		return nil, nil
+	}
+
+	var s publicIPSelection
+	if address != "" {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse public ip address '%s'", address)
+		}
+		s.address = ip
+		s.settings = append(s.settings, fmt.Sprintf("address %s", address))
+	}
+	if gateway != "" {
+		s.gateway = net.ParseIP(gateway)
+		if s.gateway == nil {
+			return nil, fmt.Errorf("couldn't parse public ip gateway '%s'", gateway)
+		}
+		s.settings = append(s.settings, fmt.Sprintf("gateway %s", gateway))
+	}
+	if ipRange != "" {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse public ip range '%s'", ipRange)
+		}
+		s.ipRange = ipNet
+		s.settings = append(s.settings, fmt.Sprintf("range %s", ipRange))
+	}
+	return &s, nil
+}
+
+// matches reports whether a public ip of the farm, or the computed ip of a vm, fits the selection
+func (s *publicIPSelection) matches(ip, gateway string) bool {
+	addr, _, err := net.ParseCIDR(ip)
+	if err != nil {
+		return false
+	}
+	if s.address != nil && !s.address.Equal(addr) {
+		return false
+	}
+	if s.gateway != nil && gateway != "" && !s.gateway.Equal(net.ParseIP(gateway)) {
+		return false
+	}
+	return s.ipRange == nil || s.ipRange.Contains(addr)
+}
+
+func (s *publicIPSelection) String() string {
+	return strings.Join(s.settings, " and ")
+}
+
+// vmsPublicIPSelections returns the public ip selections of the vms by node, then by vm name
+func vmsPublicIPSelections(vms []interface{}, defaultNode uint32) (map[uint32]map[string]*publicIPSelection, error) {
+	selections := make(map[uint32]map[string]*publicIPSelection)
+	for _, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		if vmMap["publicip"] != true {
+			continue
+		}
+		selection, err := newPublicIPSelection(vmMap)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid public ip selection of vm %s", vmMap["name"])
+		}
+		if selection == nil {
+			continue
+		}
+		node := workloadNode(vmMap, defaultNode)
+		if node == 0 {
+			// not known yet
+			continue
+		}
+		if selections[node] == nil {
+			selections[node] = make(map[string]*publicIPSelection)
+		}
+		selections[node][vmMap["name"].(string)] = selection
+	}
+	return selections, nil
+}
+
+// farmPublicIPs returns the farm of a node and its public ips, in the order the chain reserves them for new contracts
+func farmPublicIPs(sub subi.SubstrateExt, nodeID uint32) (uint32, []substrate.PublicIP, error) {
+	farmSub, ok := sub.(farmSubstrate)
+	if !ok {
+		return 0, nil, fmt.Errorf("substrate client doesn't support reading farms")
+	}
+	node, err := farmSub.GetNode(nodeID)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "couldn't get node %d", nodeID)
+	}
+	farm, err := farmSub.GetFarm(uint32(node.FarmID))
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "couldn't get farm %d", node.FarmID)
+	}
+	return uint32(farm.ID), farm.PublicIPs, nil
+}
+
+// contractPublicIPs returns the public ips a contract would hold: the ones it reserved already, or the first free ips
+// of the farm for a new contract
+func contractPublicIPs(farmIPs []substrate.PublicIP, contractID uint64, count int) []substrate.PublicIP {
+	ips := make([]substrate.PublicIP, 0)
+	for _, ip := range farmIPs {
+		if contractID != 0 && uint64(ip.ContractID) == contractID {
+			ips = append(ips, ip)
+		}
+		if contractID == 0 && ip.ContractID == 0 && len(ips) < count {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// assignPublicIPs gives each vm a distinct ip that fits its selection, the vms asking for an address go first
+func assignPublicIPs(selections map[string]*publicIPSelection, ips []substrate.PublicIP) (map[string]string, error) {
+	names := make([]string, 0, len(selections))
+	for name := range selections {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if (selections[a].address != nil) != (selections[b].address != nil) {
+			if selections[a].address != nil {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	assigned := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, name := range names {
+		for _, ip := range ips {
+			if !taken[ip.IP] && selections[name].matches(ip.IP, ip.Gateway) {
+				assigned[name] = ip.IP
+				taken[ip.IP] = true
+				break
+			}
+		}
+		if _, ok := assigned[name]; !ok {
+			return nil, fmt.Errorf("no public ip left for vm %s with %s", name, selections[name])
+		}
+	}
+	return assigned, nil
+}
+
+// publicIPsString lists public ips for error messages
+func publicIPsString(ips []substrate.PublicIP) string {
+	if len(ips) == 0 {
+		return "no public ips"
+	}
+	list := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		list = append(list, ip.IP)
+	}
+	return strings.Join(list, ", ")
+}
+
+// vmsPublicIPCount returns the number of vms with a public ipv4 on each known node, the number of ips a new contract
+// of the node reserves
+func vmsPublicIPCount(vms []interface{}, defaultNode uint32) map[uint32]int {
+	counts := make(map[uint32]int)
+	for _, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		if node := workloadNode(vmMap, defaultNode); vmMap["publicip"] == true && node != 0 {
+			counts[node]++
+		}
+	}
+	return counts
+}
+
+// checkContractPublicIPs makes sure the public ips the contract on a node would hold fit the selections of its vms
+func checkContractPublicIPs(selections map[string]*publicIPSelection, farmID, node uint32, farmIPs []substrate.PublicIP, contractID uint64, count int) error {
+	ips := contractPublicIPs(farmIPs, contractID, count)
+	if _, err := assignPublicIPs(selections, ips); err != nil {
+		if contractID != 0 {
+			return errors.Wrapf(err, "contract %d on node %d holds %s", contractID, node, publicIPsString(ips))
+		}
+		return errors.Wrapf(err, "a new contract on node %d would reserve %s, the first free public ips of farm %d", node, publicIPsString(ips), farmID)
+	}
+	return nil
+}
+
+// validatePublicIPSelections makes sure the public ips the contract of each node holds, or the first free ips of the
+// farm a new contract reserves, fit the vms selecting their public ip. counts is the number of vms with a public ip on
+// each node
+func validatePublicIPSelections(sub subi.SubstrateExt, selections map[uint32]map[string]*publicIPSelection, counts map[uint32]int, contracts map[uint32]uint64) (errs error) {
+	for node, vms := range selections {
+		farmID, farmIPs, err := farmPublicIPs(sub, node)
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(vms))
+		for name := range vms {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		var nodeErrs error
+		for _, name := range names {
+			fits := func(ip substrate.PublicIP) bool { return vms[name].matches(ip.IP, ip.Gateway) }
+			if !slices.ContainsFunc(farmIPs, fits) {
+				nodeErrs = multierror.Append(nodeErrs, fmt.Errorf("vm %s: no public ip of farm %d matches %s", name, farmID, vms[name]))
+			}
+		}
+		if nodeErrs != nil {
+			errs = multierror.Append(errs, nodeErrs)
+			continue
+		}
+
+		if err := checkContractPublicIPs(vms, farmID, node, farmIPs, contracts[node], counts[node]); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return
+}
+
+// checkPublicIPReservations makes sure the contract of each deployment holds, or will reserve, the public ips its vms
+// select. The chain doesn't take a specific ip, it reserves the first free ips of the farm for a new contract, and a
+// contract keeps its ips while it's updated, so the vms replaced on the same node get them back
+func checkPublicIPReservations(sub subi.SubstrateExt, selections map[uint32]map[string]*publicIPSelection, dls []*workloads.Deployment) error {
+	for _, dl := range dls {
+		vms := selections[dl.NodeID]
+		if len(vms) == 0 {
+			continue
+		}
+
+		farmID, farmIPs, err := farmPublicIPs(sub, dl.NodeID)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for _, vm := range dl.Vms {
+			if vm.PublicIP {
+				count++
+			}
+		}
+		if err := checkContractPublicIPs(vms, farmID, dl.NodeID, farmIPs, dl.ContractID, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSelectedPublicIPs checks the public ip reservations of the deployments against the vms configuration
+func checkSelectedPublicIPs(d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, dls []*workloads.Deployment) error {
+	selections, err := vmsPublicIPSelections(d.Get("vms").([]interface{}), uint32(d.Get("node").(int)))
+	if err != nil {
+		return err
+	}
+	return checkPublicIPReservations(tfPluginClient.SubstrateConn, selections, dls)
+}
+
+// checkVMsPublicIP fails on the vms that got a public ip of their contract that doesn't fit their selection, zos hands
+// the ips of a contract to its vms in its own order
+func checkVMsPublicIP(d *schema.ResourceData) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for i, vm := range d.Get("vms").([]interface{}) {
+		vmMap := vm.(map[string]interface{})
+		selection, err := newPublicIPSelection(vmMap)
+		if err != nil || selection == nil {
+			continue
+		}
+		if ip := vmMap["computedip"].(string); ip != "" && !selection.matches(ip, "") {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("vm %s got public ip %s, which doesn't match %s", vmMap["name"], ip, selection),
+				Detail:   fmt.Sprintf("The ip of vms.%d is one of the public ips reserved by the contract of its node, zos gave the selected ip to another vm of the node.", i),
+			})
+		}
+	}
+	return diags
+}
@@ -0,0 +1,127 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+)
+
+func TestPublicIPSelection(t *testing.T) {
+	selection := func(vm map[string]interface{}) *publicIPSelection {
+		s, err := newPublicIPSelection(vm)
+		assert.NoError(t, err)
+		return s
+	}
+
+	assert.Nil(t, selection(map[string]interface{}{"publicip_address": "", "publicip_gateway": "", "publicip_range": ""}))
+
+	address := selection(map[string]interface{}{"publicip_address": "185.206.122.33/24"})
+	assert.True(t, address.matches("185.206.122.33/24", "185.206.122.1"))
+	assert.False(t, address.matches("185.206.122.34/24", "185.206.122.1"))
+
+	filtered := selection(map[string]interface{}{"publicip_gateway": "185.206.122.1", "publicip_range": "185.206.122.32/28"})
+	assert.Equal(t, "gateway 185.206.122.1 and range 185.206.122.32/28", filtered.String())
+	assert.True(t, filtered.matches("185.206.122.40/24", "185.206.122.1"))
+	assert.True(t, filtered.matches("185.206.122.40/24", ""), "computed ips have no gateway")
+	assert.False(t, filtered.matches("185.206.122.40/24", "185.206.122.254"))
+	assert.False(t, filtered.matches("185.206.122.50/24", "185.206.122.1"))
+
+	_, err := newPublicIPSelection(map[string]interface{}{"publicip_range": "185.206.122.32"})
+	assert.Error(t, err)
+}
+
+func TestVMsPublicIPSelections(t *testing.T) {
+	vms := []interface{}{
+		map[string]interface{}{"name": "vm1", "publicip": true, "publicip_address": "185.206.122.33/24"},
+		map[string]interface{}{"name": "vm2", "publicip": true, "publicip_range": "185.206.122.32/28", "node": 12},
+		map[string]interface{}{"name": "vm3", "publicip": false},
+		map[string]interface{}{"name": "vm4", "publicip": true},
+		map[string]interface{}{"name": "vm5", "publicip": true, "publicip_address": "185.206.122.35/24", "node": unknownNode},
+	}
+
+	selections, err := vmsPublicIPSelections(vms, 11)
+	assert.NoError(t, err)
+	assert.Len(t, selections, 2)
+	assert.Contains(t, selections[11], "vm1")
+	assert.Contains(t, selections[12], "vm2")
+}
+
+func TestContractPublicIPs(t *testing.T) {
+	farmIPs := []substrate.PublicIP{
+		{IP: "185.206.122.33/24", ContractID: 7},
+		{IP: "185.206.122.34/24"},
+		{IP: "185.206.122.35/24", ContractID: 42},
+		{IP: "185.206.122.36/24"},
+		{IP: "185.206.122.37/24"},
+	}
+
+	assert.Equal(t, []substrate.PublicIP{farmIPs[1], farmIPs[3]}, contractPublicIPs(farmIPs, 0, 2), "a new contract gets the first free ips")
+	assert.Equal(t, []substrate.PublicIP{farmIPs[2]}, contractPublicIPs(farmIPs, 42, 2), "a contract keeps its ips")
+}
+
+func TestAssignPublicIPs(t *testing.T) {
+	ips := []substrate.PublicIP{
+		{IP: "185.206.122.33/24", Gateway: "185.206.122.1"},
+		{IP: "185.206.122.34/24", Gateway: "185.206.122.1"},
+	}
+	anyIP, err := newPublicIPSelection(map[string]interface{}{"publicip_gateway": "185.206.122.1"})
+	assert.NoError(t, err)
+	address, err := newPublicIPSelection(map[string]interface{}{"publicip_address": "185.206.122.33/24"})
+	assert.NoError(t, err)
+
+	assigned, err := assignPublicIPs(map[string]*publicIPSelection{"a": anyIP, "b": address}, ips)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "185.206.122.34/24", "b": "185.206.122.33/24"}, assigned, "the vms selecting an address go first")
+
+	_, err = assignPublicIPs(map[string]*publicIPSelection{"a": address, "b": address}, ips)
+	assert.ErrorContains(t, err, "no public ip left for vm b with address 185.206.122.33/24")
+}
+
+func TestVMsPublicIPCount(t *testing.T) {
+	vms := []interface{}{
+		map[string]interface{}{"name": "vm1", "publicip": true},
+		map[string]interface{}{"name": "vm2", "publicip": true, "node": 12},
+		map[string]interface{}{"name": "vm3", "publicip": false},
+		map[string]interface{}{"name": "vm4", "publicip": true, "publicip_address": "185.206.122.33/24"},
+		map[string]interface{}{"name": "vm5", "publicip": true, "node": unknownNode},
+	}
+	assert.Equal(t, map[uint32]int{11: 2, 12: 1}, vmsPublicIPCount(vms, 11))
+}
+
+func TestCheckContractPublicIPs(t *testing.T) {
+	farmIPs := []substrate.PublicIP{
+		{IP: "185.206.122.33/24", ContractID: 7},
+		{IP: "185.206.122.34/24"},
+		{IP: "185.206.122.35/24", ContractID: 42},
+		{IP: "185.206.122.36/24"},
+	}
+	selection := func(address string) map[string]*publicIPSelection {
+		s, err := newPublicIPSelection(map[string]interface{}{"publicip_address": address})
+		assert.NoError(t, err)
+		return map[string]*publicIPSelection{"vm1": s}
+	}
+
+	assert.NoError(t, checkContractPublicIPs(selection("185.206.122.34/24"), 1, 11, farmIPs, 0, 1))
+	assert.ErrorContains(t, checkContractPublicIPs(selection("185.206.122.36/24"), 1, 11, farmIPs, 0, 1),
+		"a new contract on node 11 would reserve 185.206.122.34/24, the first free public ips of farm 1", "a free ip that isn't reserved first")
+	assert.NoError(t, checkContractPublicIPs(selection("185.206.122.36/24"), 1, 11, farmIPs, 0, 2), "the other vm takes the first ip")
+	assert.NoError(t, checkContractPublicIPs(selection("185.206.122.35/24"), 1, 11, farmIPs, 42, 1))
+	assert.ErrorContains(t, checkContractPublicIPs(selection("185.206.122.34/24"), 1, 11, farmIPs, 42, 1),
+		"contract 42 on node 11 holds 185.206.122.35/24")
+}
+
+func TestCheckVMsPublicIP(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{})
+	assert.NoError(t, d.Set("vms", []interface{}{
+		map[string]interface{}{"name": "vm1", "publicip": true, "publicip_address": "185.206.122.33/24", "computedip": "185.206.122.34/24"},
+		map[string]interface{}{"name": "vm2", "publicip": true, "publicip_address": "185.206.122.34/24", "computedip": "185.206.122.34/24"},
+	}))
+
+	diags := checkVMsPublicIP(d)
+	assert.True(t, diags.HasError())
+	assert.Len(t, diags, 1)
+	assert.Equal(t, "vm vm1 got public ip 185.206.122.34/24, which doesn't match address 185.206.122.33/24", diags[0].Summary)
+}
@@ -0,0 +1,451 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+const (
+	// qsfsMetadataZDBs is the number of backends the qsfs metadata store is written to
+	qsfsMetadataZDBs = 4
+
+	qsfsMetadataZDBPrefix = "meta"
+	qsfsDataZDBPrefix     = "data"
+)
+
+// qsfsZDBs are the zdbs the provider deploys as the backends of a qsfs, in one deployment per node
+type qsfsZDBs struct {
+	dls                   []*workloads.Deployment
+	password              string
+	encryptionKey         string
+	metadataEncryptionKey string
+}
+
+// contracts returns the contract of the zdbs on each node
+func (z *qsfsZDBs) contracts() map[uint32]uint64 {
+	contracts := make(map[uint32]uint64)
+	for _, dl := range z.dls {
+		if dl.ContractID != 0 {
+			contracts[dl.NodeID] = dl.ContractID
+		}
+	}
+	return contracts
+}
+
+// zdbBackendsConfig returns the zdb_backends block of a qsfs, nil if the user builds the backends
+func zdbBackendsConfig(qsfs map[string]interface{}) map[string]interface{} {
+	blocks, _ := qsfs["zdb_backends"].([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		return nil
+	}
+	return blocks[0].(map[string]interface{})
+}
+
+// qsfsZDBsDeploymentName is the name of the deployments holding the zdbs of a qsfs
+func qsfsZDBsDeploymentName(deploymentName, qsfsName string) string {
+	return fmt.Sprintf("%s_%s", deploymentName, qsfsName)
+}
+
+// stateQSFSZDBBackends returns the zdb_backends block of each qsfs in the state by qsfs name, it holds the contracts
+// and the secrets generated by the previous applies
+func stateQSFSZDBBackends(d *schema.ResourceData) map[string]map[string]interface{} {
+	old, _ := d.GetChange("qsfs")
+	backends := make(map[string]map[string]interface{})
+	for _, q := range old.([]interface{}) {
+		qMap := q.(map[string]interface{})
+		if config := zdbBackendsConfig(qMap); config != nil {
+			backends[qMap["name"].(string)] = config
+		}
+	}
+	return backends
+}
+
+// zdbBackendsContracts parses the node_deployment_id of a zdb_backends block
+func zdbBackendsContracts(backends map[string]interface{}) (map[uint32]uint64, error) {
+	contracts := make(map[uint32]uint64)
+	ids, _ := backends["node_deployment_id"].(map[string]interface{})
+	for node, id := range ids {
+		nodeID, err := strconv.ParseUint(node, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse node id '%s'", node)
+		}
+		contracts[uint32(nodeID)] = uint64(id.(int))
+	}
+	return contracts, nil
+}
+
+// generateSecret returns a hex encoded random secret of the given number of bytes
+func generateSecret(size int) (string, error) {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.Wrap(err, "couldn't generate secret")
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// firstSecret returns the first non empty secret, or a generated one of the given number of bytes
+func firstSecret(size int, secrets ...interface{}) (string, error) {
+	for _, secret := range secrets {
+		if s, _ := secret.(string); s != "" {
+			return s, nil
+		}
+	}
+	return generateSecret(size)
+}
+
+// newQSFSZDBs builds the zdb deployments of a qsfs. The metadata zdbs and the expected shards data zdbs are spread over
+// the nodes in turn, and the secrets are the configured ones, then the ones generated by a previous apply
+func newQSFSZDBs(dl *workloads.Deployment, qsfs map[string]interface{}, state map[string]interface{}) (*qsfsZDBs, error) {
+	config := zdbBackendsConfig(qsfs)
+	qsfsName := qsfs["name"].(string)
+
+	contracts, err := zdbBackendsContracts(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadataKey interface{}
+	if metadata, _ := qsfs["metadata"].([]interface{}); len(metadata) != 0 && metadata[0] != nil {
+		metadataKey = metadata[0].(map[string]interface{})["encryption_key"]
+	}
+
+	zdbs := &qsfsZDBs{}
+	if zdbs.password, err = firstSecret(16, state["password"]); err != nil {
+		return nil, err
+	}
+	if zdbs.encryptionKey, err = firstSecret(32, qsfs["encryption_key"], state["encryption_key"]); err != nil {
+		return nil, err
+	}
+	if zdbs.metadataEncryptionKey, err = firstSecret(32, metadataKey, state["metadata_encryption_key"]); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]uint32, 0)
+	dls := make(map[uint32]*workloads.Deployment)
+	for _, node := range config["nodes"].([]interface{}) {
+		nodeID := uint32(node.(int))
+		if _, ok := dls[nodeID]; ok {
+			continue
+		}
+		nodeDeploymentID := map[uint32]uint64{}
+		if contractID := contracts[nodeID]; contractID != 0 {
+			nodeDeploymentID[nodeID] = contractID
+		}
+		nodes = append(nodes, nodeID)
+		dls[nodeID] = &workloads.Deployment{
+			Name:             qsfsZDBsDeploymentName(dl.Name, qsfsName),
+			NodeID:           nodeID,
+			SolutionProvider: dl.SolutionProvider,
+			SolutionType:     dl.SolutionType,
+			Zdbs:             make([]workloads.ZDB, 0),
+			ContractID:       contracts[nodeID],
+			NodeDeploymentID: nodeDeploymentID,
+		}
+		zdbs.dls = append(zdbs.dls, dls[nodeID])
+	}
+
+	add := func(count int, prefix, mode string, size int, description string) {
+		for i := 0; i < count; i++ {
+			zdbDl := dls[nodes[i%len(nodes)]]
+			zdbDl.Zdbs = append(zdbDl.Zdbs, workloads.ZDB{
+				Name:        fmt.Sprintf("%s%d", prefix, i),
+				Password:    zdbs.password,
+				SizeGB:      uint64(size),
+				Description: fmt.Sprintf("%s of qsfs %s", description, qsfsName),
+				Mode:        mode,
+			})
+		}
+	}
+	add(qsfsMetadataZDBs, qsfsMetadataZDBPrefix, workloads.ZDBModeUser, config["metadata_zdb_size"].(int), "metadata backend")
+	add(qsfs["expected_shards"].(int), qsfsDataZDBPrefix, workloads.ZDBModeSeq, config["data_zdb_size"].(int), "data backend")
+
+	return zdbs, nil
+}
+
+// zdbBackendAddress returns the address the qsfs reaches a zdb on, its planetary ip if it has one
+func zdbBackendAddress(zdb workloads.ZDB) string {
+	_, planetary, _ := net.ParseCIDR("200::/7")
+	var ip string
+	for _, zdbIP := range zdb.IPs {
+		parsed := net.ParseIP(zdbIP)
+		if parsed == nil {
+			continue
+		}
+		if planetary.Contains(parsed) {
+			ip = zdbIP
+			break
+		}
+		if ip == "" {
+			ip = zdbIP
+		}
+	}
+	if ip == "" {
+		return ""
+	}
+	return net.JoinHostPort(ip, fmt.Sprint(zdb.Port))
+}
+
+// qsfsBackends assembles the metadata backends and a group of data backends per node from the synced zdb deployments
+func qsfsBackends(dls []*workloads.Deployment) (metadata workloads.Backends, groups workloads.Groups, err error) {
+	metadataBackends := make(map[string]workloads.Backend)
+	for _, dl := range dls {
+		group := workloads.Group{}
+		for _, zdb := range dl.Zdbs {
+			address := zdbBackendAddress(zdb)
+			if address == "" {
+				return nil, nil, fmt.Errorf("zdb %s on node %d has no ip", zdb.Name, dl.NodeID)
+			}
+			backend := workloads.Backend{Address: address, Namespace: zdb.Namespace, Password: zdb.Password}
+			if zdb.Mode == workloads.ZDBModeUser {
+				metadataBackends[zdb.Name] = backend
+				continue
+			}
+			group.Backends = append(group.Backends, backend)
+		}
+		if len(group.Backends) != 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	for i := 0; i < qsfsMetadataZDBs; i++ {
+		name := fmt.Sprintf("%s%d", qsfsMetadataZDBPrefix, i)
+		backend, ok := metadataBackends[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("metadata zdb %s is not ready", name)
+		}
+		metadata = append(metadata, backend)
+	}
+	return metadata, groups, nil
+}
+
+// deploymentQSFS returns the qsfs workload with the given name from the deployments
+func deploymentQSFS(dls []*workloads.Deployment, name string) *workloads.QSFS {
+	for _, dl := range dls {
+		for i := range dl.QSFS {
+			if dl.QSFS[i].Name == name {
+				return &dl.QSFS[i]
+			}
+		}
+	}
+	return nil
+}
+
+// deployQSFSZDBs deploys the zdbs of the qsfs workloads with zdb_backends and sets the backends and keys of the qsfs
+// workloads of the deployments. The zdbs built so far are returned even on failure, so their contracts aren't lost
+func deployQSFSZDBs(ctx context.Context, tfPluginClient *deployer.TFPluginClient, d *schema.ResourceData, dls []*workloads.Deployment) (map[string]*qsfsZDBs, error) {
+	state := stateQSFSZDBBackends(d)
+	deployed := make(map[string]*qsfsZDBs)
+	for _, q := range d.Get("qsfs").([]interface{}) {
+		qMap := q.(map[string]interface{})
+		if zdbBackendsConfig(qMap) == nil {
+			continue
+		}
+		name := qMap["name"].(string)
+		qsfs := deploymentQSFS(dls, name)
+		if qsfs == nil {
+			return deployed, fmt.Errorf("qsfs %s is not in the deployments", name)
+		}
+
+		zdbs, err := newQSFSZDBs(dls[0], qMap, state[name])
+		if err != nil {
+			return deployed, errors.Wrapf(err, "couldn't build the zdbs of qsfs %s", name)
+		}
+		deployed[name] = zdbs
+
+		expected := make(map[*workloads.Deployment][]string)
+		for _, dl := range zdbs.dls {
+			expected[dl] = deploymentWorkloadNames(dl)
+		}
+		if err := deployDeployments(ctx, tfPluginClient, zdbs.dls); err != nil {
+			return deployed, errors.Wrapf(err, "couldn't deploy the zdbs of qsfs %s", name)
+		}
+		for _, dl := range zdbs.dls {
+			if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+				return deployed, errors.Wrapf(err, "couldn't sync the zdbs of qsfs %s on node %d", name, dl.NodeID)
+			}
+			if err := checkDeploymentHealth(expected[dl], dl); err != nil {
+				return deployed, errors.Wrapf(err, "the zdbs of qsfs %s on node %d are not healthy", name, dl.NodeID)
+			}
+		}
+
+		metadata, groups, err := qsfsBackends(zdbs.dls)
+		if err != nil {
+			return deployed, errors.Wrapf(err, "couldn't assemble the backends of qsfs %s", name)
+		}
+		if qsfs.Metadata.Type == "" {
+			qsfs.Metadata = workloads.Metadata{Type: "zdb", Prefix: name, EncryptionAlgorithm: "AES"}
+		}
+		qsfs.Metadata.EncryptionKey = zdbs.metadataEncryptionKey
+		qsfs.Metadata.Backends = metadata
+		qsfs.Groups = groups
+		qsfs.EncryptionKey = zdbs.encryptionKey
+	}
+	return deployed, nil
+}
+
+// qsfsZDBsContracts returns the zdb contracts of each qsfs
+func qsfsZDBsContracts(deployed map[string]*qsfsZDBs) map[string]map[uint32]uint64 {
+	contracts := make(map[string]map[uint32]uint64)
+	for name, zdbs := range deployed {
+		contracts[name] = zdbs.contracts()
+	}
+	return contracts
+}
+
+// createdQSFSZDBs returns the zdb deployments that didn't have a contract before the apply
+func createdQSFSZDBs(deployed map[string]*qsfsZDBs, previous map[string]map[string]interface{}) []*workloads.Deployment {
+	created := make([]*workloads.Deployment, 0)
+	for name, zdbs := range deployed {
+		contracts, _ := zdbBackendsContracts(previous[name])
+		for _, dl := range zdbs.dls {
+			if dl.ContractID != 0 && contracts[dl.NodeID] != dl.ContractID {
+				created = append(created, dl)
+			}
+		}
+	}
+	return created
+}
+
+// staleQSFSZDBContracts returns the zdb contracts in the state that the deployed zdbs don't use anymore, e.g. of
+// removed qsfs workloads or of nodes removed from their zdb_backends
+func staleQSFSZDBContracts(previous map[string]map[string]interface{}, deployed map[string]*qsfsZDBs) (map[string]map[uint32]uint64, error) {
+	stale := make(map[string]map[uint32]uint64)
+	for name, backends := range previous {
+		contracts, err := zdbBackendsContracts(backends)
+		if err != nil {
+			return nil, err
+		}
+		if zdbs, ok := deployed[name]; ok {
+			for node, contractID := range zdbs.contracts() {
+				if contracts[node] == contractID {
+					delete(contracts, node)
+				}
+			}
+		}
+		if len(contracts) != 0 {
+			stale[name] = contracts
+		}
+	}
+	return stale, nil
+}
+
+// cancelQSFSZDBContracts cancels the zdb contracts of each qsfs, and returns the ones that couldn't be canceled
+func cancelQSFSZDBContracts(ctx context.Context, tfPluginClient *deployer.TFPluginClient, deploymentName string, contracts map[string]map[uint32]uint64) (map[string]map[uint32]uint64, error) {
+	remaining := make(map[string]map[uint32]uint64)
+	var errs error
+	for name, nodeContracts := range contracts {
+		left, err := cancelNodeContracts(ctx, tfPluginClient, qsfsZDBsDeploymentName(deploymentName, name), nodeContracts)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "couldn't cancel the zdbs of qsfs %s", name))
+		}
+		if len(left) != 0 {
+			remaining[name] = left
+		}
+	}
+	return remaining, errs
+}
+
+// backendAddresses returns the addresses of a list of backends maps
+func backendAddresses(backends interface{}) []interface{} {
+	addresses := make([]interface{}, 0)
+	list, _ := backends.([]interface{})
+	for _, b := range list {
+		if bMap, ok := b.(map[string]interface{}); ok {
+			addresses = append(addresses, bMap["address"])
+		}
+	}
+	return addresses
+}
+
+// moveQSFSZDBBackends moves the backends and keys the provider generated for a synced qsfs to its zdb_backends block,
+// and sets back the configured metadata, groups and encryption key, so the generated values don't show as changes
+func moveQSFSZDBBackends(synced, configured map[string]interface{}) {
+	config := zdbBackendsConfig(configured)
+	if config == nil {
+		return
+	}
+
+	backends := make(map[string]interface{})
+	for key, value := range config {
+		backends[key] = value
+	}
+
+	backends["encryption_key"] = synced["encryption_key"]
+	if metadata, _ := synced["metadata"].([]interface{}); len(metadata) != 0 && metadata[0] != nil {
+		metadataMap := metadata[0].(map[string]interface{})
+		backends["metadata_encryption_key"] = metadataMap["encryption_key"]
+		backends["metadata_backends"] = backendAddresses(metadataMap["backends"])
+		if list, _ := metadataMap["backends"].([]interface{}); len(list) != 0 {
+			backends["password"] = list[0].(map[string]interface{})["password"]
+		}
+	}
+	dataBackends := make([]interface{}, 0)
+	groups, _ := synced["groups"].([]interface{})
+	for _, group := range groups {
+		if groupMap, ok := group.(map[string]interface{}); ok {
+			dataBackends = append(dataBackends, backendAddresses(groupMap["backends"])...)
+		}
+	}
+	backends["data_backends"] = dataBackends
+
+	synced["zdb_backends"] = []interface{}{backends}
+	synced["metadata"] = configured["metadata"]
+	synced["groups"] = configured["groups"]
+	synced["encryption_key"] = configured["encryption_key"]
+}
+
+// storeQSFSZDBBackends moves the generated backends of the synced qsfs workloads to their zdb_backends blocks and
+// records the zdb contracts of each qsfs, the contracts in the state are kept for the qsfs not in contracts
+func storeQSFSZDBBackends(d *schema.ResourceData, configured []interface{}, contracts map[string]map[uint32]uint64) error {
+	configuredQSFS := make(map[string]map[string]interface{})
+	for _, q := range configured {
+		qMap := q.(map[string]interface{})
+		name := qMap["name"].(string)
+		configuredQSFS[name] = qMap
+		if config := zdbBackendsConfig(qMap); config != nil {
+			if nodeContracts, ok := contracts[name]; ok {
+				config["node_deployment_id"] = nodeDeploymentIDMap(nodeContracts)
+			}
+		}
+	}
+
+	qsfs := d.Get("qsfs").([]interface{})
+	for _, q := range qsfs {
+		qMap := q.(map[string]interface{})
+		if c, ok := configuredQSFS[qMap["name"].(string)]; ok {
+			moveQSFSZDBBackends(qMap, c)
+		}
+	}
+
+	if err := d.Set("qsfs", qsfs); err != nil {
+		return fmt.Errorf("failed to set qsfs with error: %w", err)
+	}
+	return nil
+}
+
+// setQSFSZDBContracts sets the zdb contracts of the qsfs workloads in the state
+func setQSFSZDBContracts(d *schema.ResourceData, contracts map[string]map[uint32]uint64) error {
+	qsfs := d.Get("qsfs").([]interface{})
+	for _, q := range qsfs {
+		qMap := q.(map[string]interface{})
+		if config := zdbBackendsConfig(qMap); config != nil {
+			config["node_deployment_id"] = nodeDeploymentIDMap(contracts[qMap["name"].(string)])
+		}
+	}
+
+	if err := d.Set("qsfs", qsfs); err != nil {
+		return fmt.Errorf("failed to set qsfs with error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func TestNewQSFSZDBs(t *testing.T) {
+	dl := &workloads.Deployment{Name: "dep", SolutionType: "vm/dep"}
+	qsfs := map[string]interface{}{
+		"name":            "qsfs",
+		"expected_shards": 4,
+		"encryption_key":  "",
+		"metadata":        []interface{}{},
+		"zdb_backends": []interface{}{map[string]interface{}{
+			"nodes":             []interface{}{11, 12, 11},
+			"data_zdb_size":     10,
+			"metadata_zdb_size": 1,
+		}},
+	}
+
+	t.Run("spread over the nodes", func(t *testing.T) {
+		zdbs, err := newQSFSZDBs(dl, qsfs, nil)
+		assert.NoError(t, err)
+		assert.Len(t, zdbs.password, 32)
+		assert.Len(t, zdbs.encryptionKey, 64)
+		assert.Len(t, zdbs.metadataEncryptionKey, 64)
+
+		assert.Len(t, zdbs.dls, 2)
+		names := func(dl *workloads.Deployment) []string {
+			names := make([]string, 0)
+			for _, zdb := range dl.Zdbs {
+				assert.Equal(t, zdbs.password, zdb.Password)
+				names = append(names, zdb.Name)
+			}
+			return names
+		}
+		assert.Equal(t, uint32(11), zdbs.dls[0].NodeID)
+		assert.Equal(t, "dep_qsfs", zdbs.dls[0].Name)
+		assert.Equal(t, []string{"meta0", "meta2", "data0", "data2"}, names(zdbs.dls[0]))
+		assert.Equal(t, uint32(12), zdbs.dls[1].NodeID)
+		assert.Equal(t, []string{"meta1", "meta3", "data1", "data3"}, names(zdbs.dls[1]))
+		assert.Equal(t, workloads.ZDBModeUser, zdbs.dls[0].Zdbs[0].Mode)
+		assert.Equal(t, workloads.ZDBModeSeq, zdbs.dls[0].Zdbs[2].Mode)
+		assert.Equal(t, uint64(10), zdbs.dls[0].Zdbs[2].SizeGB)
+	})
+
+	t.Run("state is reused", func(t *testing.T) {
+		state := map[string]interface{}{
+			"node_deployment_id":      map[string]interface{}{"12": 20},
+			"password":                "pass",
+			"encryption_key":          "key",
+			"metadata_encryption_key": "metakey",
+		}
+		zdbs, err := newQSFSZDBs(dl, qsfs, state)
+		assert.NoError(t, err)
+		assert.Equal(t, "pass", zdbs.password)
+		assert.Equal(t, "key", zdbs.encryptionKey)
+		assert.Equal(t, "metakey", zdbs.metadataEncryptionKey)
+		assert.Equal(t, map[uint32]uint64{12: 20}, zdbs.contracts())
+		assert.Equal(t, map[uint32]uint64{12: 20}, zdbs.dls[1].NodeDeploymentID)
+	})
+
+	t.Run("configured keys win", func(t *testing.T) {
+		configured := map[string]interface{}{
+			"name":            "qsfs",
+			"expected_shards": 2,
+			"encryption_key":  "userkey",
+			"metadata":        []interface{}{map[string]interface{}{"encryption_key": "usermetakey"}},
+			"zdb_backends":    qsfs["zdb_backends"],
+		}
+		zdbs, err := newQSFSZDBs(dl, configured, map[string]interface{}{"encryption_key": "key", "metadata_encryption_key": "metakey"})
+		assert.NoError(t, err)
+		assert.Equal(t, "userkey", zdbs.encryptionKey)
+		assert.Equal(t, "usermetakey", zdbs.metadataEncryptionKey)
+	})
+}
+
+func TestQSFSBackends(t *testing.T) {
+	zdb := func(name, mode string, ips ...string) workloads.ZDB {
+		return workloads.ZDB{Name: name, Mode: mode, Password: "pass", Namespace: name + "_ns", Port: 9900, IPs: ips}
+	}
+	dls := []*workloads.Deployment{
+		{NodeID: 11, Zdbs: []workloads.ZDB{
+			zdb("meta0", workloads.ZDBModeUser, "2a02:1802::1", "301:1::1"),
+			zdb("meta2", workloads.ZDBModeUser, "301:1::2"),
+			zdb("data0", workloads.ZDBModeSeq, "301:1::3"),
+		}},
+		{NodeID: 12, Zdbs: []workloads.ZDB{
+			zdb("meta1", workloads.ZDBModeUser, "302:1::1"),
+			zdb("meta3", workloads.ZDBModeUser, "302:1::2"),
+			zdb("data1", workloads.ZDBModeSeq, "185.1.1.1"),
+		}},
+	}
+
+	metadata, groups, err := qsfsBackends(dls)
+	assert.NoError(t, err)
+	assert.Equal(t, workloads.Backends{
+		{Address: "[301:1::1]:9900", Namespace: "meta0_ns", Password: "pass"},
+		{Address: "[302:1::1]:9900", Namespace: "meta1_ns", Password: "pass"},
+		{Address: "[301:1::2]:9900", Namespace: "meta2_ns", Password: "pass"},
+		{Address: "[302:1::2]:9900", Namespace: "meta3_ns", Password: "pass"},
+	}, metadata)
+	assert.Equal(t, workloads.Groups{
+		{Backends: workloads.Backends{{Address: "[301:1::3]:9900", Namespace: "data0_ns", Password: "pass"}}},
+		{Backends: workloads.Backends{{Address: "185.1.1.1:9900", Namespace: "data1_ns", Password: "pass"}}},
+	}, groups)
+
+	dls[1].Zdbs = dls[1].Zdbs[1:]
+	_, _, err = qsfsBackends(dls)
+	assert.ErrorContains(t, err, "metadata zdb meta1 is not ready")
+}
+
+func TestStaleQSFSZDBContracts(t *testing.T) {
+	previous := map[string]map[string]interface{}{
+		"kept":    {"node_deployment_id": map[string]interface{}{"11": 1, "12": 2}},
+		"removed": {"node_deployment_id": map[string]interface{}{"11": 3}},
+	}
+	deployed := map[string]*qsfsZDBs{
+		"kept": {dls: []*workloads.Deployment{{NodeID: 11, ContractID: 1}, {NodeID: 13, ContractID: 4}}},
+	}
+
+	stale, err := staleQSFSZDBContracts(previous, deployed)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[uint32]uint64{
+		"kept":    {12: 2},
+		"removed": {11: 3},
+	}, stale)
+	assert.Equal(t, []*workloads.Deployment{deployed["kept"].dls[1]}, createdQSFSZDBs(deployed, previous))
+}
+
+func TestMoveQSFSZDBBackends(t *testing.T) {
+	configured := map[string]interface{}{
+		"name":           "qsfs",
+		"encryption_key": "",
+		"metadata":       []interface{}{},
+		"groups":         []interface{}{},
+		"zdb_backends": []interface{}{map[string]interface{}{
+			"nodes":              []interface{}{11},
+			"node_deployment_id": map[string]interface{}{"11": 1},
+		}},
+	}
+	backend := func(address string) interface{} {
+		return map[string]interface{}{"address": address, "namespace": "ns", "password": "pass"}
+	}
+	synced := map[string]interface{}{
+		"name":           "qsfs",
+		"encryption_key": "key",
+		"metadata": []interface{}{map[string]interface{}{
+			"encryption_key": "metakey",
+			"backends":       []interface{}{backend("[301::1]:9900"), backend("[301::2]:9900")},
+		}},
+		"groups": []interface{}{
+			map[string]interface{}{"backends": []interface{}{backend("[301::3]:9900")}},
+			map[string]interface{}{"backends": []interface{}{backend("[302::3]:9900")}},
+		},
+	}
+
+	moveQSFSZDBBackends(synced, configured)
+	assert.Equal(t, "", synced["encryption_key"])
+	assert.Equal(t, []interface{}{}, synced["metadata"])
+	assert.Equal(t, []interface{}{}, synced["groups"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"nodes":                   []interface{}{11},
+		"node_deployment_id":      map[string]interface{}{"11": 1},
+		"password":                "pass",
+		"encryption_key":          "key",
+		"metadata_encryption_key": "metakey",
+		"metadata_backends":       []interface{}{"[301::1]:9900", "[301::2]:9900"},
+		"data_backends":           []interface{}{"[301::3]:9900", "[302::3]:9900"},
+	}}, synced["zdb_backends"])
+}
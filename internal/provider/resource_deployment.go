@@ -4,34 +4,47 @@ package provider
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net/http"
 	"regexp"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceDeployment() *schema.Resource {
 	return &schema.Resource{
 		// This description is used by the documentation generator and the language server.
-		Description:   "Resource for deploying multiple workloads like vms (ZMachines), ZDBs, disks, Qsfss, and/or zlogs. A user should specify node id for this deployment, the (already) deployed network that this deployment should be a part of, and the desired workloads configurations.",
-		CreateContext: resourceDeploymentCreate,
-		ReadContext:   resourceDeploymentRead,
-		UpdateContext: resourceDeploymentUpdate,
-		DeleteContext: resourceDeploymentDelete,
+		Description:   "Resource for deploying multiple workloads like vms (ZMachines), ZDBs, disks, volumes, Qsfss, and/or zlogs. A user should specify node id for this deployment, which workloads could override to be placed on other nodes with one contract per node, the (already) deployed network that this deployment should be a part of, and the desired workloads configurations.",
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceDeploymentCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceDeploymentRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceDeploymentUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, withDeletionProtection(resourceDeploymentDelete)),
+		CustomizeDiff: resourceDeploymentCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceDeploymentImport,
+		},
 
 		Timeouts: &schema.ResourceTimeout{
 			Create: schema.DefaultTimeout(45 * time.Minute),
+			Update: schema.DefaultTimeout(45 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+			Read:   schema.DefaultTimeout(10 * time.Minute),
 		},
 
 		Schema: map[string]*schema.Schema{
 			"node": {
 				Type:        schema.TypeInt,
 				Required:    true,
-				Description: "Node id to place the deployment on.",
+				Description: "Node id to place the deployment on. Workloads that don't set their own `node` are placed on it.",
 			},
 			"name": {
 				Type:             schema.TypeString,
@@ -52,10 +65,23 @@ func resourceDeployment() *schema.Resource {
 				Default:     0,
 				Description: "Solution provider ID for the deployed solution which allows the creator of the solution to gain a percentage of the rewards.",
 			},
+			"migration_cutover_wait": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "",
+				Description:      "Time to wait after the workloads are healthy on their new nodes before canceling the contracts on the nodes they all left, e.g. when `node` changes (e.g. 10m). Both deployments run during this window.",
+				ValidateDiagFunc: validation.ToDiagFunc(validateDuration),
+			},
 			"ip_range": {
 				Type:        schema.TypeString,
 				Computed:    true,
-				Description: "IP range of the node for the wireguard network (e.g. 10.1.2.0/24). Has to have a subnet mask of 24.",
+				Description: "IP range of the deployment node for the wireguard network (e.g. 10.1.2.0/24). Has to have a subnet mask of 24.",
+			},
+			"node_deployment_id": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Description: "Mapping from each node to its deployment contract id.",
 			},
 			"network_name": {
 				Type:        schema.TypeString,
@@ -74,6 +100,11 @@ func resourceDeployment() *schema.Resource {
 							Description:      "Disk workload name. This has to be unique within the deployment. Must contain only alphanumeric and underscore characters.",
 							ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
 						},
+						"node": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Node id to place the disk on. Defaults to the deployment `node`. Vms can only mount disks on their own node.",
+						},
 						"size": {
 							Type:             schema.TypeInt,
 							Required:         true,
@@ -86,6 +117,68 @@ func resourceDeployment() *schema.Resource {
 							Default:     "",
 							Description: "Description of disk workload.",
 						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "State of the disk on the node, e.g. `ok`, `error` or `paused`.",
+						},
+						"message": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Error message of the disk if it failed on the node.",
+						},
+						"version": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Version of the disk on the node, increased each time it's updated.",
+						},
+					},
+				},
+			},
+			"volumes": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "List of volume workloads configurations. Volumes are shared with the vms through virtiofs, which zos light nodes prefer over disks.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:             schema.TypeString,
+							Required:         true,
+							Description:      "Volume workload name. This has to be unique within the deployment. Must contain only alphanumeric and underscore characters.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
+						},
+						"node": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Node id to place the volume on. Defaults to the deployment `node`. Vms can only mount volumes on their own node.",
+						},
+						"size": {
+							Type:             schema.TypeInt,
+							Required:         true,
+							Description:      "Volume size in GBs. Must be between 1GB and 10240GBs (10TBs)",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IntBetween(1, 10*1024)),
+						},
+						"description": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "",
+							Description: "Description of volume workload.",
+						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "State of the volume on the node, e.g. `ok`, `error` or `paused`.",
+						},
+						"message": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Error message of the volume if it failed on the node.",
+						},
+						"version": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Version of the volume on the node, increased each time it's updated.",
+						},
 					},
 				},
 			},
@@ -101,6 +194,11 @@ func resourceDeployment() *schema.Resource {
 							Description:      "ZDB workload name. This has to be unique within the deployment. Must contain only alphanumeric and underscore characters.",
 							ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
 						},
+						"node": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Node id to place the zdb on. Defaults to the deployment `node`.",
+						},
 						"password": {
 							Type:        schema.TypeString,
 							Required:    true,
@@ -147,6 +245,21 @@ func resourceDeployment() *schema.Resource {
 							Computed:    true,
 							Description: "Port of the ZDB.",
 						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "State of the zdb on the node, e.g. `ok`, `error` or `paused`.",
+						},
+						"message": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Error message of the zdb if it failed on the node.",
+						},
+						"version": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Version of the zdb on the node, increased each time it's updated.",
+						},
 					},
 				},
 			},
@@ -165,7 +278,7 @@ func resourceDeployment() *schema.Resource {
 						"node": {
 							Type:        schema.TypeInt,
 							Optional:    true,
-							Description: "Node id to place the vm on.",
+							Description: "Node id to place the vm on. Defaults to the deployment `node`.",
 						},
 						"flist": {
 							Type:        schema.TypeString,
@@ -187,6 +300,24 @@ func resourceDeployment() *schema.Resource {
 							Optional:    true,
 							Description: "Flag to enable public ipv6 reservation.",
 						},
+						"publicip_address": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							Description:      "Public ipv4 of the farm of the vm node to reserve, as the farm lists it (e.g. 185.206.122.33/24). The chain doesn't reserve a specific ip: a new contract of the node reserves the first free ips of the farm, one per vm with `publicip`, and an updated contract keeps its ips, so the vms replaced on the same node get them back. The plan fails if those ips don't include the address, and the apply fails if zos gives it to another vm of the contract. Requires `publicip`.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IsCIDR),
+						},
+						"publicip_gateway": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							Description:      "Only reserve a public ipv4 of the farm with this gateway, checked against the ips the contract of the node reserves like `publicip_address`. Requires `publicip`.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPv4Address),
+						},
+						"publicip_range": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							Description:      "Only reserve a public ipv4 of the farm within this range (e.g. 185.206.122.32/28), checked against the ips the contract of the node reserves like `publicip_address`. Requires `publicip`.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IsCIDR),
+						},
 						"computedip": {
 							Type:        schema.TypeString,
 							Computed:    true,
@@ -201,7 +332,7 @@ func resourceDeployment() *schema.Resource {
 							Type:             schema.TypeString,
 							Optional:         true,
 							Computed:         true,
-							Description:      "The private wireguard IP of the vm.",
+							Description:      "The private wireguard IP of the vm. Must be within the deployment `ip_range`.",
 							ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress),
 						},
 						"mycelium_ip_seed": {
@@ -247,13 +378,13 @@ func resourceDeployment() *schema.Resource {
 						"mounts": {
 							Type:        schema.TypeList,
 							Optional:    true,
-							Description: "List of vm (ZMachine) mounts. Can reference QSFSs and Disks.",
+							Description: "List of vm (ZMachine) mounts. Can reference Disks, Volumes and QSFSs. Zos light nodes can't serve QSFSs. The image of a full vm flist is written to the first mount, which must be a disk at least as large as the image.",
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
 									"name": {
 										Type:        schema.TypeString,
 										Required:    true,
-										Description: "Name of QSFS or Disk to mount.",
+										Description: "Name of the Disk, Volume or QSFS to mount.",
 									},
 									"mount_point": {
 										Type:        schema.TypeString,
@@ -269,6 +400,23 @@ func resourceDeployment() *schema.Resource {
 							Elem:        &schema.Schema{Type: schema.TypeString},
 							Description: "Environment variables to pass to the zmachine.",
 						},
+						"secure_env_vars": {
+							Type:             schema.TypeMap,
+							Optional:         true,
+							Sensitive:        true,
+							Elem:             &schema.Schema{Type: schema.TypeString},
+							DiffSuppressFunc: suppressSecureEnvDiff,
+							Description:      "Sensitive environment variables to pass to the zmachine, merged with `env_vars` on deployment. Their values are hidden in plans and only their sha256 hashes are kept in the state, a value changed on the node still shows up as a diff. A key can't be set in both `env_vars` and `secure_env_vars`.",
+						},
+						"ssh_keys": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "List of SSH public keys allowed to access the vm. They are passed to the vm in the `SSH_KEY` env var, one key per line, which zos adds to the cloud-init users of full vms and the official flists add to `authorized_keys`. Can't be used together with an `SSH_KEY` entry in `env_vars` or `secure_env_vars`.",
+							Elem: &schema.Schema{
+								Type:             schema.TypeString,
+								ValidateDiagFunc: validation.ToDiagFunc(validateSSHKey),
+							},
+						},
 						"planetary": {
 							Type:        schema.TypeBool,
 							Optional:    true,
@@ -309,6 +457,70 @@ func resourceDeployment() *schema.Resource {
 							Computed:    true,
 							Description: "The url to access the vm via cloud console on private interface using wireguard.",
 						},
+						"wait_for": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							MaxItems:    1,
+							Description: "Readiness check run after the vm is deployed. Applying waits until the vm accepts connections on the given port, instead of returning as soon as the workload is deployed.",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"network": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "Vm address to check, one of `private`, `planetary`, `mycelium`, `public` or `public6`. If not set, all the vm addresses are tried.",
+										ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(
+											[]string{vmNetworkPrivate, vmNetworkPlanetary, vmNetworkMycelium, vmNetworkPublic, vmNetworkPublic6},
+											false,
+										)),
+									},
+									"port": {
+										Type:             schema.TypeInt,
+										Required:         true,
+										Description:      "TCP port to connect to.",
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IsPortNumber),
+									},
+									"http_path": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "If set, an HTTP GET request is sent to this path (e.g. /healthz) instead of only opening a TCP connection.",
+									},
+									"http_status": {
+										Type:             schema.TypeInt,
+										Optional:         true,
+										Default:          http.StatusOK,
+										Description:      "Expected HTTP status of the `http_path` request.",
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IntBetween(100, 599)),
+									},
+									"timeout": {
+										Type:             schema.TypeString,
+										Optional:         true,
+										Default:          "10m",
+										Description:      "Time to wait for the vm to be ready (e.g. 10m).",
+										ValidateDiagFunc: validation.ToDiagFunc(validateDuration),
+									},
+								},
+							},
+						},
+						"ready_address": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The address (ip:port) the vm passed its `wait_for` check on.",
+						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "State of the vm on the node, e.g. `ok`, `error` or `paused`.",
+						},
+						"message": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Error message of the vm if it failed on the node.",
+						},
+						"version": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Version of the vm on the node, increased each time it's updated.",
+						},
 					},
 				},
 			},
@@ -324,6 +536,11 @@ func resourceDeployment() *schema.Resource {
 							Description:      "Qsfs workload name. This has to be unique within the deployment. Must contain only alphanumeric and underscore characters.",
 							ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
 						},
+						"node": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Node id to place the qsfs on. Defaults to the deployment `node`. Vms can only mount qsfss on their own node.",
+						},
 						"description": {
 							Type:        schema.TypeString,
 							Optional:    true,
@@ -368,8 +585,8 @@ func resourceDeployment() *schema.Resource {
 						},
 						"encryption_key": {
 							Type:        schema.TypeString,
-							Required:    true,
-							Description: "64 long hex encoded encryption key (e.g. 0000000000000000000000000000000000000000000000000000000000000000).",
+							Optional:    true,
+							Description: "64 long hex encoded encryption key (e.g. 0000000000000000000000000000000000000000000000000000000000000000). Required unless `zdb_backends` is set, which generates one.",
 						},
 						"compression_algorithm": {
 							Type:        schema.TypeString,
@@ -378,10 +595,10 @@ func resourceDeployment() *schema.Resource {
 							Description: "configuration to use for the compression stage. Currently only snappy is supported.",
 						},
 						"metadata": {
-							Type:     schema.TypeList,
-							Required: true,
-							MaxItems: 1,
-							MinItems: 1,
+							Type:        schema.TypeList,
+							Optional:    true,
+							MaxItems:    1,
+							Description: "The metadata store configuration. Required unless `zdb_backends` is set, which generates one with the qsfs name as prefix, or fills the backends of the given one.",
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
 									"type": {
@@ -403,8 +620,8 @@ func resourceDeployment() *schema.Resource {
 									},
 									"encryption_key": {
 										Type:        schema.TypeString,
-										Required:    true,
-										Description: "64 long hex encoded encryption key (e.g. 0000000000000000000000000000000000000000000000000000000000000000).",
+										Optional:    true,
+										Description: "64 long hex encoded encryption key (e.g. 0000000000000000000000000000000000000000000000000000000000000000). Required unless the qsfs sets `zdb_backends`, which generates one.",
 									},
 									"backends": {
 										Type:        schema.TypeList,
@@ -434,8 +651,8 @@ func resourceDeployment() *schema.Resource {
 						},
 						"groups": {
 							Type:        schema.TypeList,
-							Required:    true,
-							Description: "The backend groups to write the data to.",
+							Optional:    true,
+							Description: "The backend groups to write the data to. Required unless `zdb_backends` is set.",
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
 									"backends": {
@@ -465,14 +682,104 @@ func resourceDeployment() *schema.Resource {
 								},
 							},
 						},
+						"zdb_backends": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							MaxItems:    1,
+							Description: "Deploys the zdbs of the qsfs on the given nodes instead of taking hand-built `metadata` backends and `groups`. The provider deploys " + fmt.Sprint(qsfsMetadataZDBs) + " metadata zdbs and `expected_shards` data zdbs spread over the nodes, groups the data zdbs of each node, generates the encryption keys that aren't given, and cancels the zdbs with the qsfs.",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"nodes": {
+										Type:        schema.TypeList,
+										Required:    true,
+										MinItems:    1,
+										Description: "Node ids to deploy the zdbs on, e.g. the nodes found by a `grid_scheduler`. Each node holds one backend group.",
+										Elem: &schema.Schema{
+											Type: schema.TypeInt,
+										},
+									},
+									"data_zdb_size": {
+										Type:             schema.TypeInt,
+										Required:         true,
+										Description:      "Size of each data zdb in GBs.",
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+									},
+									"metadata_zdb_size": {
+										Type:             schema.TypeInt,
+										Optional:         true,
+										Default:          1,
+										Description:      "Size of each metadata zdb in GBs.",
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+									},
+									"node_deployment_id": {
+										Type:        schema.TypeMap,
+										Computed:    true,
+										Description: "Mapping from each node to the id of the contract holding the zdbs on it.",
+										Elem: &schema.Schema{
+											Type: schema.TypeInt,
+										},
+									},
+									"password": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Sensitive:   true,
+										Description: "Generated password of the zdb namespaces.",
+									},
+									"encryption_key": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Sensitive:   true,
+										Description: "Encryption key of the qsfs data, generated if the qsfs doesn't set one.",
+									},
+									"metadata_encryption_key": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Sensitive:   true,
+										Description: "Encryption key of the qsfs metadata, generated if the qsfs `metadata` doesn't set one.",
+									},
+									"metadata_backends": {
+										Type:        schema.TypeList,
+										Computed:    true,
+										Description: "Addresses of the metadata zdbs.",
+										Elem: &schema.Schema{
+											Type: schema.TypeString,
+										},
+									},
+									"data_backends": {
+										Type:        schema.TypeList,
+										Computed:    true,
+										Description: "Addresses of the data zdbs.",
+										Elem: &schema.Schema{
+											Type: schema.TypeString,
+										},
+									},
+								},
+							},
+						},
 						"metrics_endpoint": {
 							Type:        schema.TypeString,
 							Computed:    true,
 							Description: "QSFS exposed metrics endpoint.",
 						},
+						"state": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "State of the qsfs on the node, e.g. `ok`, `error` or `paused`.",
+						},
+						"message": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Error message of the qsfs if it failed on the node.",
+						},
+						"version": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Version of the qsfs on the node, increased each time it's updated.",
+						},
 					},
 				},
 			},
+			"deletion_protection": deletionProtectionSchema(),
 		},
 	}
 }
@@ -484,23 +791,90 @@ func resourceDeploymentCreate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
-	dl, err := newDeploymentFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	dls, err := newDeploymentsFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn, &tfPluginClient.State.Networks)
 	if err != nil {
 		return diag.Errorf("couldn't load deployment data with error: %v", err)
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
-		return diag.Errorf("couldn't deploy deployment with error: %v", err)
+	if err := validateDeploymentsIP(dls); err != nil {
+		return diag.Errorf("invalid vms ips with error: %v", err)
+	}
+
+	if err := checkSelectedPublicIPs(d, tfPluginClient, dls); err != nil {
+		return diag.Errorf("couldn't reserve the selected public ips with error: %v", err)
+	}
+
+	configured := configuredWorkloads(d)
+	zdbs, err := deployQSFSZDBs(ctx, tfPluginClient, d, dls)
+	if err != nil {
+		return append(diag.Errorf("couldn't deploy qsfs backends with error: %v", err), cancelCreatedDeployments(ctx, tfPluginClient, createdQSFSZDBs(zdbs, nil))...)
+	}
+
+	zdbContracts := qsfsZDBsContracts(zdbs)
+	if err := deployDeployments(ctx, tfPluginClient, dls); err != nil {
+		diags = diag.Errorf("couldn't deploy deployment with error: %v", err)
+		if !hasContracts(dls) {
+			return append(diags, cancelCreatedDeployments(ctx, tfPluginClient, createdQSFSZDBs(zdbs, nil))...)
+		}
+		// the resource is tainted by the failed create, so the next apply cancels the contracts left on the nodes
+		if err := trackDeploymentContracts(d, dls, uint32(d.Get("node").(int))); err != nil {
+			return append(diags, diag.Errorf("couldn't track deployment contracts with error: %v", err)...)
+		}
+		// record the workloads that were deployed and the errors of the failed ones, even if the create timed out
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+		return append(diags, storeDeployments(cleanupCtx, d, tfPluginClient, dls, configured, zdbContracts)...)
+	}
+
+	diags = append(diags, storeDeployments(ctx, d, tfPluginClient, dls, configured, zdbContracts)...)
+	if diags.HasError() {
+		return diags
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
-		return diag.Errorf("couldn't sync deployment with error: %v", err)
+	diags = append(diags, checkVMsPublicIP(d)...)
+	diags = append(diags, waitForDeploymentVMs(ctx, d, dls)...)
+	return diags
+}
+
+// storeDeployments syncs the deployments from their nodes and stores them along with the results of their workloads
+// and the zdb contracts of the qsfs workloads
+func storeDeployments(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, dls []*workloads.Deployment, configured map[string][]interface{}, zdbContracts map[string]map[uint32]uint64) diag.Diagnostics {
+	for _, dl := range dls {
+		if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+			return diag.Errorf("couldn't sync deployment on node %d with error: %v", dl.NodeID, err)
+		}
 	}
 
-	if err := syncContractsDeployments(d, dl); err != nil {
+	if err := syncContractsDeployments(d, dls, uint32(d.Get("node").(int))); err != nil {
 		return diag.Errorf("couldn't set deployment data to the resource with error: %v", err)
 	}
 
+	if err := storeQSFSZDBBackends(d, configured["qsfs"], zdbContracts); err != nil {
+		return diag.Errorf("couldn't set qsfs backends to the resource with error: %v", err)
+	}
+
+	return recordWorkloadResults(ctx, d, tfPluginClient, dls, configured)
+}
+
+// waitForDeploymentVMs runs the vms readiness checks and stores the addresses they were reached on
+func waitForDeploymentVMs(ctx context.Context, d *schema.ResourceData, dls []*workloads.Deployment) diag.Diagnostics {
+	checks, err := parseVMReadinessChecks(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	var diags diag.Diagnostics
+	reached, err := waitForVMs(ctx, dls, checks)
+	if err != nil {
+		diags = diag.Errorf("couldn't wait for vms to be ready with error: %v", err)
+	}
+
+	if err := setVMsReadyAddress(d, reached); err != nil {
+		diags = append(diags, diag.Errorf("couldn't set vms ready address with error: %v", err)...)
+	}
 	return diags
 }
 
@@ -511,24 +885,32 @@ func resourceDeploymentRead(ctx context.Context, d *schema.ResourceData, meta in
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
-	dl, err := newDeploymentFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	dls, err := newDeploymentsFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn, &tfPluginClient.State.Networks)
 	if err != nil {
 		return diag.Errorf("couldn't load deployment data with error: %v", err)
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
-		diags = append(diags, diag.Diagnostic{
-			Severity: diag.Warning,
-			Summary:  "failed to read deployment data (terraform refresh might help)",
-			Detail:   err.Error(),
-		})
-		return diags
+	configured := configuredWorkloads(d)
+	for _, dl := range dls {
+		if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  "failed to read deployment data (terraform refresh might help)",
+				Detail:   err.Error(),
+			})
+			return diags
+		}
 	}
 
-	if err := syncContractsDeployments(d, dl); err != nil {
+	if err := syncContractsDeployments(d, dls, uint32(d.Get("node").(int))); err != nil {
 		return diag.Errorf("couldn't set deployment data to the resource with error: %v", err)
 	}
 
+	if err := storeQSFSZDBBackends(d, configured["qsfs"], nil); err != nil {
+		return diag.Errorf("couldn't set qsfs backends to the resource with error: %v", err)
+	}
+
+	diags = append(diags, recordWorkloadResults(ctx, d, tfPluginClient, dls, configured)...)
 	return diags
 }
 
@@ -539,61 +921,311 @@ func resourceDeploymentUpdate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
-	if d.HasChange("node") {
-		oldContractID, err := strconv.ParseUint(d.Id(), 10, 64)
-		if err != nil {
-			return diag.Errorf("couldn't parse deployment id %s with error: %v", d.Id(), err)
+	contracts, err := nodeDeploymentIDs(d)
+	if err != nil {
+		return diag.Errorf("couldn't load deployment contracts with error: %v", err)
+	}
+
+	dls, err := newDeploymentsFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn, &tfPluginClient.State.Networks)
+	if err != nil {
+		return diag.Errorf("couldn't load deployment data with error: %v", err)
+	}
+
+	canceled, err := deploymentCanceledContracts(d, contracts, dls)
+	if err != nil {
+		return diag.Errorf("couldn't load qsfs backends contracts with error: %v", err)
+	}
+	if diags := checkUpdateProtection(d, canceled); diags.HasError() {
+		return diags
+	}
+
+	if err := validateDeploymentsIP(dls); err != nil {
+		return diag.Errorf("invalid vms ips with error: %v", err)
+	}
+
+	if err := checkSelectedPublicIPs(d, tfPluginClient, dls); err != nil {
+		return diag.Errorf("couldn't reserve the selected public ips with error: %v", err)
+	}
+
+	// the qsfs backends are deployed first, so the qsfs workloads are updated with their addresses
+	previousZDBs := stateQSFSZDBBackends(d)
+	zdbs, err := deployQSFSZDBs(ctx, tfPluginClient, d, dls)
+	if err != nil {
+		return append(diag.Errorf("couldn't deploy qsfs backends with error: %v", err), cancelCreatedDeployments(ctx, tfPluginClient, createdQSFSZDBs(zdbs, previousZDBs))...)
+	}
+	staleZDBs, err := staleQSFSZDBContracts(previousZDBs, zdbs)
+	if err != nil {
+		return diag.Errorf("couldn't load qsfs backends contracts with error: %v", err)
+	}
+	zdbContracts := qsfsZDBsContracts(zdbs)
+
+	// the nodes that are left by all the workloads
+	removed := maps.Clone(contracts)
+	created := make([]*workloads.Deployment, 0)
+	for _, dl := range dls {
+		delete(removed, dl.NodeID)
+		if dl.ContractID == 0 {
+			created = append(created, dl)
+		}
+	}
+
+	migrated := make([]*workloads.Deployment, 0)
+	if len(removed) != 0 && len(created) != 0 {
+		if diags := migrateDeployment(ctx, d, tfPluginClient, created, removed); diags.HasError() {
+			// the state isn't updated, so the qsfs backends deployed for the update are canceled with the new deployments
+			return append(diags, cancelCreatedDeployments(ctx, tfPluginClient, createdQSFSZDBs(zdbs, previousZDBs))...)
+		}
+		migrated = created
+	}
+
+	configured := configuredWorkloads(d)
+	var errs error
+	for _, dl := range dls {
+		if slices.Contains(migrated, dl) {
+			continue
+		}
+		if err := deployDeployment(ctx, tfPluginClient, dl); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "couldn't update deployment on node %d", dl.NodeID))
+		}
+	}
+
+	if errs != nil {
+		// the qsfs workloads might still use their previous zdbs, so their contracts are kept
+		for name, contracts := range staleZDBs {
+			if _, ok := zdbContracts[name]; !ok {
+				ids := make([]string, 0, len(contracts))
+				for node, contractID := range contracts {
+					ids = append(ids, fmt.Sprintf("%d on node %d", contractID, node))
+				}
+				slices.Sort(ids)
+				diags = append(diags, diag.Diagnostic{
+					Severity: diag.Warning,
+					Summary:  fmt.Sprintf("the zdbs of the removed qsfs %s are kept", name),
+					Detail:   fmt.Sprintf("Cancel their contracts %s once the qsfs is removed from its node.", strings.Join(ids, ", ")),
+				})
+				continue
+			}
+			for node, contractID := range contracts {
+				zdbContracts[name][node] = contractID
+			}
 		}
-		err = tfPluginClient.SubstrateConn.CancelContract(tfPluginClient.Identity, oldContractID)
-		if err != nil {
-			return diag.Errorf("couldn't cancel old node contract with error: %v", err)
+
+		// record the workloads that were updated and the errors of the failed ones, even if the update timed out
+		diags = append(diags, diag.Errorf("couldn't update deployment with error: %v", errs)...)
+		// the contracts of the nodes the workloads left are only canceled once all the nodes are updated
+		if err := trackDeploymentContracts(d, dls, uint32(d.Get("node").(int))); err != nil {
+			return append(diags, diag.Errorf("couldn't track deployment contracts with error: %v", err)...)
+		}
+		if err := keepNodeContracts(d, removed); err != nil {
+			return append(diags, diag.Errorf("couldn't track deployment contracts with error: %v", err)...)
+		}
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+		diags = append(diags, storeDeployments(cleanupCtx, d, tfPluginClient, dls, configured, zdbContracts)...)
+		if err := keepNodeContracts(d, removed); err != nil {
+			return append(diags, diag.Errorf("couldn't track deployment contracts with error: %v", err)...)
 		}
-		d.SetId("")
+		return diags
 	}
 
-	dl, err := newDeploymentFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	remaining, err := cancelNodeContracts(ctx, tfPluginClient, d.Get("name").(string), removed)
 	if err != nil {
-		return diag.Errorf("couldn't load deployment data with error: %v", err)
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "couldn't cancel the contracts of the nodes the workloads left, the next apply retries",
+			Detail:   err.Error(),
+		})
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
-		return diag.Errorf("couldn't update deployment with error: %v", err)
+	if _, err := cancelQSFSZDBContracts(ctx, tfPluginClient, d.Get("name").(string), staleZDBs); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "couldn't cancel the contracts of the zdbs the qsfs workloads left",
+			Detail:   err.Error(),
+		})
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
-		return diag.Errorf("couldn't sync deployment with error: %v", err)
+	diags = append(diags, storeDeployments(ctx, d, tfPluginClient, dls, configured, zdbContracts)...)
+	// the contracts that failed to cancel stay tracked
+	if err := keepNodeContracts(d, remaining); err != nil {
+		diags = append(diags, diag.Errorf("couldn't track deployment contracts with error: %v", err)...)
+	}
+	if diags.HasError() {
+		return diags
 	}
 
-	if err := syncContractsDeployments(d, dl); err != nil {
-		return diag.Errorf("couldn't set deployment data to the resource with error: %v", err)
+	diags = append(diags, checkVMsPublicIP(d)...)
+	diags = append(diags, waitForDeploymentVMs(ctx, d, dls)...)
+	return diags
+}
+
+// migrateDeployment deploys on the new nodes first and only lets the contracts of the nodes the workloads left be
+// canceled once the new deployments are healthy
+func migrateDeployment(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, created []*workloads.Deployment, removed map[uint32]uint64) diag.Diagnostics {
+	nodes := make([]uint32, 0, len(removed))
+	for node := range removed {
+		nodes = append(nodes, node)
 	}
+	oldNodes := formatNodes(nodes)
+
+	// keep the old nodes in the state if the migration fails
+	d.Partial(true)
+
+	expected := make(map[*workloads.Deployment][]string)
+	names := make([]string, 0)
+	for _, dl := range created {
+		expected[dl] = deploymentWorkloadNames(dl)
+		names = append(names, expected[dl]...)
+	}
+
+	for _, dl := range created {
+		if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
+			return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.Errorf("couldn't deploy on node %d, the deployments on nodes %s are kept, with error: %v", dl.NodeID, oldNodes, err)...)
+		}
+	}
+
+	for _, dl := range created {
+		if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+			return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.Errorf("couldn't sync deployment on node %d, the deployments on nodes %s are kept, with error: %v", dl.NodeID, oldNodes, err)...)
+		}
+
+		if err := checkDeploymentHealth(expected[dl], dl); err != nil {
+			return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.Errorf("deployment on node %d is not healthy, the deployments on nodes %s are kept, with error: %v", dl.NodeID, oldNodes, err)...)
+		}
+	}
+
+	checks, err := parseVMReadinessChecks(d)
+	if err != nil {
+		return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.FromErr(err)...)
+	}
+	// only the vms on the new nodes are checked, the others are updated after the cut-over
+	maps.DeleteFunc(checks, func(name string, _ vmReadinessCheck) bool {
+		return !slices.Contains(names, name)
+	})
+	if _, err := waitForVMs(ctx, created, checks); err != nil {
+		return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.Errorf("vms on the new nodes are not ready, the deployments on nodes %s are kept, with error: %v", oldNodes, err)...)
+	}
+
+	if wait := d.Get("migration_cutover_wait").(string); wait != "" {
+		// the value is validated by the schema
+		duration, _ := time.ParseDuration(wait)
+		select {
+		case <-time.After(duration):
+		case <-ctx.Done():
+			return append(cancelCreatedDeployments(ctx, tfPluginClient, created), diag.Errorf("migration cut-over was interrupted, the deployments on nodes %s are kept, with error: %v", oldNodes, ctx.Err())...)
+		}
+	}
+
+	d.Partial(false)
+	return nil
+}
+
+// cancelCreatedDeployments cancels the contracts a failed apply created, e.g. the new deployments of a failed migration
+// or the qsfs backends deployed for a failed update, and warns about the ones it couldn't cancel
+func cancelCreatedDeployments(ctx context.Context, tfPluginClient *deployer.TFPluginClient, dls []*workloads.Deployment) diag.Diagnostics {
+	// the new contracts are canceled even if the apply timed out
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	var diags diag.Diagnostics
+	for _, dl := range dls {
+		if dl.ContractID == 0 {
+			continue
+		}
 
+		contractID := dl.ContractID
+		if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, dl); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("couldn't cancel contract %d on node %d", contractID, dl.NodeID),
+				Detail:   err.Error(),
+			})
+		}
+	}
 	return diags
 }
 
+func validateDuration(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if v == "" {
+		return nil, nil
+	}
+	if d, err := time.ParseDuration(v); err != nil || d < 0 {
+		return nil, []error{fmt.Errorf("expected %s to be a positive duration (e.g. 10m), got %s", k, v)}
+	}
+	return nil, nil
+}
+
 func resourceDeploymentDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
 	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
 	if !ok {
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
-	dl, err := newDeploymentFromSchema(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
+	contracts, err := nodeDeploymentIDs(d)
 	if err != nil {
-		return diag.Errorf("couldn't load deployment data with error: %v", err)
+		return diag.Errorf("couldn't load deployment contracts with error: %v", err)
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, dl); err != nil {
+	remaining, err := cancelNodeContracts(ctx, tfPluginClient, d.Get("name").(string), contracts)
+	if err != nil {
+		// keep tracking the contracts that couldn't be canceled
+		if err := d.Set("node_deployment_id", nodeDeploymentIDMap(remaining)); err != nil {
+			return diag.Errorf("couldn't set node deployment id with error: %v", err)
+		}
 		return diag.Errorf("couldn't cancel deployment with error: %v", err)
 	}
 
-	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
-		return diag.Errorf("couldn't sync deployment with error: %v", err)
+	// the qsfs backends are canceled once the qsfs workloads are gone
+	zdbContracts := make(map[string]map[uint32]uint64)
+	for name, backends := range stateQSFSZDBBackends(d) {
+		if zdbContracts[name], err = zdbBackendsContracts(backends); err != nil {
+			return diag.Errorf("couldn't load qsfs backends contracts with error: %v", err)
+		}
+	}
+	remainingZDBs, err := cancelQSFSZDBContracts(ctx, tfPluginClient, d.Get("name").(string), zdbContracts)
+	if err != nil {
+		if err := d.Set("node_deployment_id", nodeDeploymentIDMap(remaining)); err != nil {
+			return diag.Errorf("couldn't set node deployment id with error: %v", err)
+		}
+		if err := setQSFSZDBContracts(d, remainingZDBs); err != nil {
+			return diag.Errorf("couldn't set qsfs backends contracts with error: %v", err)
+		}
+		// the deployment contracts are gone, only the zdbs are left to cancel
+		d.SetId("0")
+		return diag.Errorf("couldn't cancel qsfs backends with error: %v", err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceDeploymentImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
 	}
 
-	if err := syncContractsDeployments(d, dl); err != nil {
-		return diag.Errorf("couldn't set deployment data to the resource with error: %v", err)
+	nodeID, contractID, err := parseDeploymentImportID(d.Id())
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse import id '%s', expected 'contract_id' or 'node_id:contract_id'", d.Id())
 	}
 
-	return diags
+	dl, err := loadDeploymentFromContract(ctx, tfPluginClient, nodeID, contractID)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't import deployment")
+	}
+
+	if err := d.Set("name", dl.Name); err != nil {
+		return nil, errors.Wrap(err, "couldn't set deployment name")
+	}
+
+	if err := syncContractsDeployments(d, []*workloads.Deployment{dl}, dl.NodeID); err != nil {
+		return nil, errors.Wrap(err, "couldn't set deployment data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
 }
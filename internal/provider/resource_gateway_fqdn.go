@@ -5,11 +5,13 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceGatewayFQDNProxy() *schema.Resource {
@@ -17,10 +19,17 @@ func resourceGatewayFQDNProxy() *schema.Resource {
 		// This description is used by the documentation generator and the language server.
 		Description: "Resource for deploying a gateway with a fully qualified domain name.\nA user should have some fully qualified domain name (fqdn) (e.g. example.com), pointing to the specified node working as a gateway, then connect this gateway to whichever backend services they desire, making these backend services accessible through the computed fqdn.",
 
-		CreateContext: resourceGatewayFQDNCreate,
-		ReadContext:   resourceGatewayFQDNRead,
-		UpdateContext: resourceGatewayFQDNUpdate,
-		DeleteContext: resourceGatewayFQDNDelete,
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceGatewayFQDNCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceGatewayFQDNRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceGatewayFQDNUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, withDeletionProtection(resourceGatewayFQDNDelete)),
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -78,6 +87,7 @@ func resourceGatewayFQDNProxy() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from each node to its deployment id.",
 			},
+			"deletion_protection": deletionProtectionSchema(),
 		},
 	}
 }
@@ -95,7 +105,9 @@ func resourceGatewayFQDNCreate(ctx context.Context, d *schema.ResourceData, meta
 	}
 
 	if err := tfPluginClient.GatewayFQDNDeployer.Deploy(ctx, gw); err != nil {
-		return diag.Errorf("couldn't deploy fqdn gateway with error: %v", err)
+		diags = diag.Errorf("couldn't deploy fqdn gateway with error: %v", err)
+		// failed to deploy and failed to revert, store the contracts that are still valid so the tainted gateway gets cleaned up
+		return append(diags, storePartialFQDNGateway(ctx, d, tfPluginClient, gw)...)
 	}
 
 	if err := tfPluginClient.GatewayFQDNDeployer.Sync(ctx, gw); err != nil {
@@ -116,13 +128,20 @@ func resourceGatewayFQDNUpdate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
+	oldNode, newNode := d.GetChange("node")
+	removed := removedNodes([]uint32{uint32(oldNode.(int))}, []uint32{uint32(newNode.(int))})
+	if diags := checkUpdateProtection(d, nodeContractsCanceled("gateway deployment", removed)); diags.HasError() {
+		return diags
+	}
+
 	gw, err := newFQDNGatewayFromSchema(d)
 	if err != nil {
 		return diag.Errorf("couldn't load fqdn gateway data with error: %v", err)
 	}
 
 	if err := tfPluginClient.GatewayFQDNDeployer.Deploy(ctx, gw); err != nil {
-		return diag.Errorf("couldn't update fqdn gateway with error: %v", err)
+		diags = diag.Errorf("couldn't update fqdn gateway with error: %v", err)
+		return append(diags, storePartialFQDNGateway(ctx, d, tfPluginClient, gw)...)
 	}
 
 	if err := tfPluginClient.GatewayFQDNDeployer.Sync(ctx, gw); err != nil {
@@ -190,3 +209,24 @@ func resourceGatewayFQDNDelete(ctx context.Context, d *schema.ResourceData, meta
 
 	return diags
 }
+
+// storePartialFQDNGateway syncs the contracts a failed deployment left behind, and stores them if any of them is still valid
+func storePartialFQDNGateway(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, gw *workloads.GatewayFQDNProxy) diag.Diagnostics {
+	var diags diag.Diagnostics
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	gw.ContractID = gw.NodeDeploymentID[gw.NodeID]
+	if err := tfPluginClient.GatewayFQDNDeployer.Sync(ctx, gw); err != nil {
+		// the contracts are stored unsynced rather than orphaned
+		diags = diag.Errorf("couldn't sync fqdn gateway with error: %v", err)
+	}
+	if !hasNodeContracts(gw.NodeDeploymentID) {
+		return diags
+	}
+
+	if err := syncContractsFQDNGateways(d, gw); err != nil {
+		diags = append(diags, diag.Errorf("couldn't set fqdn gateway data to the resource with error: %v", err)...)
+	}
+	return diags
+}
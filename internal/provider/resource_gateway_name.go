@@ -5,21 +5,31 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
 func resourceGatewayNameProxy() *schema.Resource {
 	return &schema.Resource{
 		// This description is used by the documentation generator and the language server.
 		Description:   "Resource for deploying a gateway name workload. A user should specify some unique name, for example hamada, and a node working as a gateway that has the domain gent01.dev.grid.tf, and the grid generates a fully qualified domain name (fqdn) `hamada.getn01.dev.grid.tf`. Then, the user could connect this gateway workload to whichever backend services the user desires, making these backend services accessible through the computed fqdn.",
-		CreateContext: resourceGatewayNameCreate,
-		ReadContext:   resourceGatewayNameRead,
-		UpdateContext: resourceGatewayNameUpdate,
-		DeleteContext: resourceGatewayNameDelete,
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceGatewayNameCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceGatewayNameRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceGatewayNameUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, withDeletionProtection(resourceGatewayNameDelete)),
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -75,11 +85,18 @@ func resourceGatewayNameProxy() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from each node to its deployment id.",
 			},
+			"name_contract": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "ID of a `grid_name_contract` reserving the name. The gateway uses it instead of creating its own name contract, and leaves it in place when the gateway is destroyed or replaced.",
+			},
 			"name_contract_id": {
 				Type:        schema.TypeInt,
 				Computed:    true,
-				Description: "The id of the created name contract.",
+				Description: "The id of the name contract used by the gateway, either created by it or the reserved `name_contract`.",
 			},
+			"deletion_protection": deletionProtectionSchema(),
 		},
 	}
 }
@@ -96,8 +113,10 @@ func resourceGatewayNameCreate(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("couldn't load name gateway data with error: %v", err)
 	}
 
-	if err := tfPluginClient.GatewayNameDeployer.Deploy(ctx, gw); err != nil {
-		return diag.Errorf("couldn't deploy name gateway with error: %v", err)
+	if err := deployNameGateway(ctx, d, tfPluginClient, gw); err != nil {
+		diags = diag.Errorf("couldn't deploy name gateway with error: %v", err)
+		// failed to deploy and failed to revert, store the contracts that are still valid so the tainted gateway gets cleaned up
+		return append(diags, storePartialNameGateway(ctx, d, tfPluginClient, gw)...)
 	}
 
 	if err := tfPluginClient.GatewayNameDeployer.Sync(ctx, gw); err != nil {
@@ -122,9 +141,33 @@ func resourceGatewayNameUpdate(ctx context.Context, d *schema.ResourceData, meta
 	if err != nil {
 		return diag.Errorf("couldn't load name gateway data with error: %v", err)
 	}
+	replacedNameContract := ownedNameContract(d)
+
+	oldNode, newNode := d.GetChange("node")
+	removed := removedNodes([]uint32{uint32(oldNode.(int))}, []uint32{uint32(newNode.(int))})
+	canceled := nodeContractsCanceled("gateway deployment", removed)
+	if replacedNameContract != 0 && (d.HasChange("name") || d.Get("name_contract").(int) != 0) {
+		// the name contract is replaced by a new or a reserved one
+		canceled = append(canceled, fmt.Sprintf("name contract %d", replacedNameContract))
+	}
+	if diags := checkUpdateProtection(d, canceled); diags.HasError() {
+		return diags
+	}
+
+	if err := deployNameGateway(ctx, d, tfPluginClient, gw); err != nil {
+		diags = diag.Errorf("couldn't update name gateway with error: %v", err)
+		return append(diags, storePartialNameGateway(ctx, d, tfPluginClient, gw)...)
+	}
 
-	if err := tfPluginClient.GatewayNameDeployer.Deploy(ctx, gw); err != nil {
-		return diag.Errorf("couldn't update name gateway with error: %v", err)
+	if d.Get("name_contract").(int) != 0 && replacedNameContract != 0 && replacedNameContract != gw.NameContractID {
+		// the gateway moved to a reserved name, its own name contract isn't used anymore
+		if err := tfPluginClient.SubstrateConn.EnsureContractCanceled(tfPluginClient.Identity, replacedNameContract); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("couldn't cancel name contract %d, cancel it manually", replacedNameContract),
+				Detail:   err.Error(),
+			})
+		}
 	}
 
 	if err := tfPluginClient.GatewayNameDeployer.Sync(ctx, gw); err != nil {
@@ -178,6 +221,11 @@ func resourceGatewayNameDelete(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("couldn't load name gateway data with error: %v", err)
 	}
 
+	if d.Get("name_contract").(int) != 0 {
+		// the reserved name outlives the gateway
+		gw.NameContractID = 0
+	}
+
 	if err := tfPluginClient.GatewayNameDeployer.Cancel(ctx, gw); err != nil {
 		return diag.Errorf("couldn't cancel name gateway with error: %v", err)
 	}
@@ -192,3 +240,67 @@ func resourceGatewayNameDelete(ctx context.Context, d *schema.ResourceData, meta
 
 	return diags
 }
+
+// storePartialNameGateway syncs the contracts a failed deployment left behind, and stores them if any of them is still valid
+func storePartialNameGateway(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, gw *workloads.GatewayNameProxy) diag.Diagnostics {
+	var diags diag.Diagnostics
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	gw.ContractID = gw.NodeDeploymentID[gw.NodeID]
+	if err := tfPluginClient.GatewayNameDeployer.Sync(ctx, gw); err != nil {
+		// the contracts are stored unsynced rather than orphaned
+		diags = diag.Errorf("couldn't sync name gateway with error: %v", err)
+	}
+	if !hasNodeContracts(gw.NodeDeploymentID) && (gw.NameContractID == 0 || d.Get("name_contract").(int) != 0) {
+		// a reserved name contract isn't the gateway's to clean up
+		return diags
+	}
+
+	if err := syncContractsNameGateways(d, gw); err != nil {
+		diags = append(diags, diag.Errorf("couldn't set name gateway data to the resource with error: %v", err)...)
+	}
+	return diags
+}
+
+// deployNameGateway deploys the gateway, a gateway on a reserved name contract never creates nor cancels its name contract
+func deployNameGateway(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, gw *workloads.GatewayNameProxy) error {
+	if d.Get("name_contract").(int) == 0 {
+		return tfPluginClient.GatewayNameDeployer.Deploy(ctx, gw)
+	}
+
+	// the name contract is checked first, the gateway deployer cancels name contracts that don't match the gateway name
+	name, err := loadNameContract(tfPluginClient, gw.NameContractID)
+	if err != nil {
+		return errors.Wrap(err, "couldn't use the reserved name contract")
+	}
+	if name != gw.Name {
+		return fmt.Errorf("name contract %d reserves '%s', not '%s'", gw.NameContractID, name, gw.Name)
+	}
+
+	if err := tfPluginClient.GatewayNameDeployer.Validate(ctx, gw); err != nil {
+		return err
+	}
+	dls, err := tfPluginClient.GatewayNameDeployer.GenerateVersionlessDeployments(ctx, gw)
+	if err != nil {
+		return errors.Wrap(err, "couldn't generate deployments data")
+	}
+
+	nodeDeployer := deployer.NewDeployer(*tfPluginClient, true)
+	gw.NodeDeploymentID, err = nodeDeployer.Deploy(ctx, gw.NodeDeploymentID, dls, map[uint32]*uint64{gw.NodeID: nil})
+
+	// the contract is tracked even if the deployment failed
+	if contractID, ok := gw.NodeDeploymentID[gw.NodeID]; ok && contractID != 0 {
+		gw.ContractID = contractID
+		tfPluginClient.State.StoreContractIDs(gw.NodeID, gw.ContractID)
+	}
+	return err
+}
+
+// ownedNameContract returns the name contract the gateway created for itself before the update, if any
+func ownedNameContract(d *schema.ResourceData) uint64 {
+	if oldReserved, _ := d.GetChange("name_contract"); oldReserved.(int) != 0 {
+		return 0
+	}
+	return uint64(d.Get("name_contract_id").(int))
+}
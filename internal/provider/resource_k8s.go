@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -12,6 +13,8 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
 )
 
 func resourceKubernetes() *schema.Resource {
@@ -19,10 +22,20 @@ func resourceKubernetes() *schema.Resource {
 		// This description is used by the documentation generator and the language server.
 		Description: "Resource to deploy a kubernetes cluster. A cluster should consist of one master node, and a number (could be zero) of worker nodes.",
 
-		CreateContext: resourceK8sCreate,
-		ReadContext:   resourceK8sRead,
-		UpdateContext: resourceK8sUpdate,
-		DeleteContext: resourceK8sDelete,
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceK8sCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceK8sRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceK8sUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, withDeletionProtection(resourceK8sDelete)),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceK8sImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(60 * time.Minute),
+			Update: schema.DefaultTimeout(60 * time.Minute),
+			Delete: schema.DefaultTimeout(20 * time.Minute),
+			Read:   schema.DefaultTimeout(10 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -69,6 +82,15 @@ func resourceKubernetes() *schema.Resource {
 				Default:     "",
 				Description: "SSH key to access the cluster nodes.",
 			},
+			"ssh_keys": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "List of SSH public keys allowed to access the cluster nodes, added to `ssh_key` in the `SSH_KEY` env var of every node, one key per line.",
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validation.ToDiagFunc(validateSSHKey),
+				},
+			},
 			"token": {
 				Type:             schema.TypeString,
 				Required:         true,
@@ -322,6 +344,7 @@ func resourceKubernetes() *schema.Resource {
 					},
 				},
 			},
+			"deletion_protection": deletionProtectionSchema(),
 		},
 	}
 }
@@ -339,7 +362,13 @@ func resourceK8sCreate(ctx context.Context, d *schema.ResourceData, meta interfa
 	}
 
 	if err := tfPluginClient.K8sDeployer.Deploy(ctx, k8sCluster); err != nil {
-		return diag.Errorf("couldn't deploy k8s cluster with error: %v", err)
+		diags = diag.Errorf("couldn't deploy k8s cluster with error: %v", err)
+		if !hasNodeContracts(k8sCluster.NodeDeploymentID) {
+			return diags
+		}
+		// failed to deploy and failed to revert, store the current state so the tainted cluster gets cleaned up
+		d.SetId(uuid.New().String())
+		return append(diags, storePartialK8sState(ctx, d, tfPluginClient, k8sCluster)...)
 	}
 
 	err = tfPluginClient.K8sDeployer.UpdateFromRemote(ctx, k8sCluster)
@@ -356,6 +385,26 @@ func resourceK8sCreate(ctx context.Context, d *schema.ResourceData, meta interfa
 	return diags
 }
 
+// storePartialK8sState stores the contracts and workloads of a cluster that failed to deploy part-way
+func storePartialK8sState(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, k8sCluster *workloads.K8sCluster) diag.Diagnostics {
+	var diags diag.Diagnostics
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	if err := tfPluginClient.K8sDeployer.UpdateFromRemote(ctx, k8sCluster); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "couldn't update k8s cluster from remote, the configured cluster is stored",
+			Detail:   err.Error(),
+		})
+	}
+
+	if err := storeK8sState(d, k8sCluster); err != nil {
+		diags = append(diags, diag.FromErr(err)...)
+	}
+	return diags
+}
+
 func resourceK8sUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
@@ -363,13 +412,22 @@ func resourceK8sUpdate(ctx context.Context, d *schema.ResourceData, meta interfa
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
+	oldMaster, newMaster := d.GetChange("master")
+	oldWorkers, newWorkers := d.GetChange("workers")
+	removed := removedNodes(k8sNodes(oldMaster, oldWorkers), k8sNodes(newMaster, newWorkers))
+	if diags := checkUpdateProtection(d, nodeContractsCanceled("cluster deployments", removed)); diags.HasError() {
+		return diags
+	}
+
 	k8sCluster, err := newK8sFromSchema(d)
 	if err != nil {
 		return diag.Errorf("couldn't load k8s cluster data with error: %v", err)
 	}
 
 	if err := tfPluginClient.K8sDeployer.Deploy(ctx, k8sCluster); err != nil {
-		return diag.Errorf("couldn't update k8s cluster with error: %v", err)
+		// store the contracts the failed update left on the nodes
+		diags = diag.Errorf("couldn't update k8s cluster with error: %v", err)
+		return append(diags, storePartialK8sState(ctx, d, tfPluginClient, k8sCluster)...)
 	}
 
 	err = tfPluginClient.K8sDeployer.UpdateFromRemote(ctx, k8sCluster)
@@ -441,3 +499,73 @@ func resourceK8sDelete(ctx context.Context, d *schema.ResourceData, meta interfa
 	d.SetId("")
 	return nil
 }
+
+func resourceK8sImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	name, err := parseNamedImportID(d.Id(), k8sImportPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	contracts, err := twinContracts(tfPluginClient)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterContracts, ok := contracts[workloads.K8sType][name]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find a kubernetes cluster named %s for twin %d", name, tfPluginClient.TwinID)
+	}
+
+	nodes := make([]uint32, 0, len(clusterContracts))
+	for node := range clusterContracts {
+		nodes = append(nodes, node)
+	}
+
+	// the cluster network isn't known before loading the cluster, so all the twin networks are searched for it
+	networkContracts := make([]map[uint32][]uint64, 0, len(contracts[workloads.NetworkType]))
+	for _, nodeContracts := range contracts[workloads.NetworkType] {
+		networkContracts = append(networkContracts, nodeContracts)
+	}
+
+	st := newImportState(tfPluginClient, append(networkContracts, clusterContracts)...)
+	cluster, err := st.LoadK8sFromGrid(ctx, nodes, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load kubernetes cluster %s", name)
+	}
+
+	// keep the network subnets locally so that later updates could assign ips in the cluster network
+	subnets := make(map[uint32]zos.IPNet)
+	for node, subnet := range st.Networks.GetNetwork(cluster.NetworkName).Subnets {
+		ipNet, err := zos.ParseIPNet(subnet)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse subnet of node %d", node)
+		}
+		subnets[node] = ipNet
+	}
+	tfPluginClient.State.Networks.UpdateNetworkSubnets(cluster.NetworkName, subnets)
+
+	if err := d.Set("name", name); err != nil {
+		return nil, errors.Wrap(err, "couldn't set kubernetes cluster name")
+	}
+	if err := d.Set("flist", cluster.Flist); err != nil {
+		return nil, errors.Wrap(err, "couldn't set kubernetes cluster flist")
+	}
+	if err := d.Set("flist_checksum", cluster.FlistChecksum); err != nil {
+		return nil, errors.Wrap(err, "couldn't set kubernetes cluster flist checksum")
+	}
+	if err := d.Set("entrypoint", cluster.Entrypoint); err != nil {
+		return nil, errors.Wrap(err, "couldn't set kubernetes cluster entrypoint")
+	}
+
+	if err := storeK8sState(d, &cluster); err != nil {
+		return nil, err
+	}
+
+	d.SetId(uuid.New().String())
+	return []*schema.ResourceData{d}, nil
+}
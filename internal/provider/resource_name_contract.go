@@ -0,0 +1,182 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+)
+
+func resourceNameContract() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description:   "Resource for reserving a gateway name for the twin with a name contract, independent of any gateway. A `grid_name_proxy` that references it through `name_contract` uses the reserved name and leaves it in place when the gateway is destroyed or replaced.",
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceNameContractCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceNameContractRead),
+		DeleteContext: withTimeout(schema.TimeoutDelete, resourceNameContractDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceNameContractImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:             schema.TypeString,
+				Required:         true,
+				ForceNew:         true,
+				Description:      "Gateway name to reserve. The fqdn of a gateway using it will be <name>.<gateway-domain>. Must contain only alphanumeric and underscore characters.",
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
+			},
+			"contract_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the name contract.",
+			},
+		},
+	}
+}
+
+func resourceNameContractCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	name := d.Get("name").(string)
+	nameContractID, err := tfPluginClient.SubstrateConn.GetContractIDByNameRegistration(name)
+	if err != nil && !errors.Is(err, substrate.ErrNotFound) {
+		return diag.Errorf("couldn't get name contract of '%s' with error: %v", name, err)
+	}
+	if nameContractID != 0 {
+		return diag.Errorf("name '%s' is already reserved by contract %d, import it if it's owned by twin %d", name, nameContractID, tfPluginClient.TwinID)
+	}
+
+	contractID, err := tfPluginClient.SubstrateConn.CreateNameContract(tfPluginClient.Identity, name)
+	if err != nil {
+		return diag.Errorf("couldn't reserve name '%s' with error: %v", name, err)
+	}
+	d.SetId(fmt.Sprint(contractID))
+
+	return resourceNameContractRead(ctx, d, meta)
+}
+
+func resourceNameContractRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("couldn't parse name contract id '%s' with error: %v", d.Id(), err)
+	}
+
+	name, err := loadNameContract(tfPluginClient, contractID)
+	if errors.Is(err, substrate.ErrNotFound) {
+		// the contract was canceled outside of terraform
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "failed to read name contract (terraform refresh might help)",
+			Detail:   err.Error(),
+		})
+		return diags
+	}
+
+	if err := syncNameContract(d, name, contractID); err != nil {
+		return diag.Errorf("couldn't set name contract data to the resource with error: %v", err)
+	}
+
+	return diags
+}
+
+func resourceNameContractDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("couldn't parse name contract id '%s' with error: %v", d.Id(), err)
+	}
+
+	if err := tfPluginClient.SubstrateConn.EnsureContractCanceled(tfPluginClient.Identity, contractID); err != nil {
+		return diag.Errorf("couldn't cancel name contract %d with error: %v", contractID, err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceNameContractImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse import id '%s', expected 'contract_id'", d.Id())
+	}
+
+	name, err := loadNameContract(tfPluginClient, contractID)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't import name contract")
+	}
+
+	if err := syncNameContract(d, name, contractID); err != nil {
+		return nil, errors.Wrap(err, "couldn't set name contract data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// loadNameContract makes sure the contract is a name contract of the twin and returns its name
+func loadNameContract(tfPluginClient *deployer.TFPluginClient, contractID uint64) (string, error) {
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if contract.IsDeleted() {
+		return "", errors.Wrapf(substrate.ErrNotFound, "contract %d is deleted", contractID)
+	}
+	if !contract.ContractType.IsNameContract {
+		return "", fmt.Errorf("contract %d is not a name contract", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return "", fmt.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+	return contract.ContractType.NameContract.Name, nil
+}
+
+// syncNameContract updates the terraform local state with the name contract
+func syncNameContract(d *schema.ResourceData, name string, contractID uint64) (errors error) {
+	if err := d.Set("name", name); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("failed to set name with error: %w", err))
+	}
+	if err := d.Set("contract_id", int(contractID)); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("failed to set contract id with error: %w", err))
+	}
+	d.SetId(fmt.Sprint(contractID))
+	return
+}
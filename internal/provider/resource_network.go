@@ -6,9 +6,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"net"
 	"regexp"
 	"slices"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
@@ -29,10 +31,20 @@ func resourceNetwork() *schema.Resource {
 		// This description is used by the documentation generator and the language server.
 		Description: "Resource to deploy a network on the grid. This is a private wireguard network. A user could specify that they want to have a user access endpoint to this network through the `add_wg_access` flag. A separate workload is deployed on each of the specified nodes, with the peers for each workload configured in a way making any pair of nodes in the network accessible to each other.",
 
-		CreateContext: resourceNetworkCreate,
-		ReadContext:   resourceNetworkRead,
-		UpdateContext: resourceNetworkUpdate,
-		DeleteContext: resourceNetworkDelete,
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceNetworkCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceNetworkRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceNetworkUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, withDeletionProtection(resourceNetworkDelete)),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceNetworkImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(20 * time.Minute),
+			Update: schema.DefaultTimeout(20 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -112,6 +124,7 @@ func resourceNetwork() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from each node to its deployment id.",
 			},
+			"deletion_protection": deletionProtectionSchema(),
 		},
 	}
 }
@@ -387,6 +400,12 @@ func resourceNetworkUpdate(ctx context.Context, d *schema.ResourceData, meta int
 		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
 	}
 
+	oldNodes, newNodes := d.GetChange("nodes")
+	removed := removedNodes(parseUint32List(oldNodes.([]interface{})), parseUint32List(newNodes.([]interface{})))
+	if diags := checkUpdateProtection(d, nodeContractsCanceled("network deployments", removed)); diags.HasError() {
+		return diags
+	}
+
 	net, err := newNetwork(ctx, d, tfPluginClient.NcPool, tfPluginClient.SubstrateConn)
 	if err != nil {
 		return diag.FromErr(errors.Wrap(err, "couldn't load network data"))
@@ -456,3 +475,94 @@ func resourceNetworkDelete(ctx context.Context, d *schema.ResourceData, meta int
 	}
 	return diags
 }
+
+func resourceNetworkImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	name, err := parseNamedImportID(d.Id(), networkImportPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	contracts, err := twinContracts(tfPluginClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var net workloads.Network
+	if nodeContracts, ok := contracts[networkLightType][name]; ok {
+		znet, err := newImportState(tfPluginClient, nodeContracts).LoadNetworkLightFromGrid(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load network %s", name)
+		}
+		// network light workloads don't hold the network ip range
+		znet.IPRange = networkIPRange(znet.NodesIPRange)
+		net = &znet
+	} else if nodeContracts, ok := contracts[workloads.NetworkType][name]; ok {
+		znet, err := newImportState(tfPluginClient, nodeContracts).LoadNetworkFromGrid(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't load network %s", name)
+		}
+		net = &znet
+	} else {
+		return nil, fmt.Errorf("couldn't find a network named %s for twin %d", name, tfPluginClient.TwinID)
+	}
+
+	if err := storeImportedNetwork(d, net); err != nil {
+		return nil, err
+	}
+
+	if err := storeState(d, tfPluginClient, net); err != nil {
+		return nil, err
+	}
+
+	d.SetId(uuid.New().String())
+	return []*schema.ResourceData{d}, nil
+}
+
+// storeImportedNetwork sets the network configuration that is only known from the resource configuration in normal runs
+func storeImportedNetwork(d *schema.ResourceData, net workloads.Network) (errors error) {
+	myceliumKeys := make(map[string]interface{})
+	for node, key := range net.GetMyceliumKeys() {
+		myceliumKeys[fmt.Sprintf("%d", node)] = hex.EncodeToString(key)
+	}
+
+	err := d.Set("name", net.GetName())
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	err = d.Set("description", net.GetDescription())
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	err = d.Set("solution_type", net.GetSolutionType())
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	err = d.Set("add_wg_access", net.GetAddWGAccess())
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	err = d.Set("mycelium_keys", myceliumKeys)
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	}
+
+	return
+}
+
+// networkIPRange returns the /16 network range containing the nodes ip ranges
+func networkIPRange(nodesIPRange map[uint32]zos.IPNet) zos.IPNet {
+	for _, r := range nodesIPRange {
+		mask := net.CIDRMask(16, 32)
+		return zos.IPNet{IPNet: net.IPNet{IP: r.IP.Mask(mask), Mask: mask}}
+	}
+	return zos.IPNet{}
+}
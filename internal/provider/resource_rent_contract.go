@@ -0,0 +1,310 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	substrate "github.com/threefoldtech/tfchain/clients/tfchain-client-go"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+)
+
+// rentSubstrate is the part of the substrate client that manages the rent contracts of nodes
+type rentSubstrate interface {
+	CreateRentContract(identity substrate.Identity, node uint32, solutionProviderID *uint64) (uint64, error)
+	GetNodeRentContract(node uint32) (uint64, error)
+	GetNodeContracts(node uint32) ([]types.U64, error)
+}
+
+// rentLock serializes the rent extrinsics when the substrate client isn't the grid client one
+var rentLock sync.Mutex
+
+// extrinsicsLock returns the mutex the grid client substrate client holds while sending its extrinsics, the twin
+// nonce is read from the chain for each extrinsic so the ones sent concurrently by other resources collide
+func extrinsicsLock(sub subi.SubstrateExt) sync.Locker {
+	impl, ok := sub.(*subi.SubstrateImpl)
+	if !ok || impl == nil {
+		return &rentLock
+	}
+	m := reflect.ValueOf(impl).Elem().FieldByName("m")
+	if !m.IsValid() || m.Type() != reflect.TypeOf(sync.Mutex{}) {
+		return &rentLock
+	}
+	return (*sync.Mutex)(unsafe.Pointer(m.UnsafeAddr()))
+}
+
+// createRentContract rents the node under the extrinsics lock of the substrate client, the grid client doesn't
+// wrap renting nodes like the other contracts extrinsics
+func createRentContract(sub subi.SubstrateExt, identity substrate.Identity, node uint32, solutionProviderID *uint64) (uint64, error) {
+	rent, ok := sub.(rentSubstrate)
+	if !ok {
+		return 0, fmt.Errorf("substrate client doesn't support rent contracts")
+	}
+	lock := extrinsicsLock(sub)
+	lock.Lock()
+	defer lock.Unlock()
+	return rent.CreateRentContract(identity, node, solutionProviderID)
+}
+
+func resourceRentContract() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description:   "Resource for renting a node for the twin, so only the twin can deploy on it. A rented node can be found with a `grid_scheduler` request that sets `dedicated = true`.",
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceRentContractCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceRentContractRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceRentContractUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, resourceRentContractDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceRentContractImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(5 * time.Minute),
+			Update: schema.DefaultTimeout(5 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Node id to rent.",
+			},
+			"solution_provider": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "ID for a deployed solution which the provider provide.",
+			},
+			"force": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Cancel the contracts of the twin on the node when the rent contract is destroyed. Otherwise destroying the rent contract fails while other contracts on the node still exist.",
+			},
+			"contract_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the rent contract.",
+			},
+			"monthly_price": {
+				Type:        schema.TypeFloat,
+				Computed:    true,
+				Description: "Monthly price of the rented node in USD, as reported by the grid proxy.",
+			},
+		},
+	}
+}
+
+func resourceRentContractCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+	sub, ok := tfPluginClient.SubstrateConn.(rentSubstrate)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("substrate client doesn't support rent contracts"))
+	}
+
+	nodeID := uint32(d.Get("node").(int))
+	rentContractID, err := sub.GetNodeRentContract(nodeID)
+	if err != nil && !errors.Is(err, substrate.ErrNotFound) {
+		return diag.Errorf("couldn't get rent contract of node %d with error: %v", nodeID, err)
+	}
+	if rentContractID != 0 {
+		return diag.Errorf("node %d is already rented by contract %d, import it if it's owned by twin %d", nodeID, rentContractID, tfPluginClient.TwinID)
+	}
+
+	var solutionProvider *uint64
+	if provider := uint64(d.Get("solution_provider").(int)); provider != 0 {
+		solutionProvider = &provider
+	}
+
+	contractID, err := createRentContract(tfPluginClient.SubstrateConn, tfPluginClient.Identity, nodeID, solutionProvider)
+	if err != nil {
+		return diag.Errorf("couldn't rent node %d with error: %v", nodeID, err)
+	}
+	d.SetId(fmt.Sprint(contractID))
+
+	return resourceRentContractRead(ctx, d, meta)
+}
+
+func resourceRentContractRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("couldn't parse rent contract id '%s' with error: %v", d.Id(), err)
+	}
+
+	nodeID, err := loadRentContract(tfPluginClient, contractID)
+	if errors.Is(err, substrate.ErrNotFound) {
+		// the contract was canceled outside of terraform
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "failed to read rent contract (terraform refresh might help)",
+			Detail:   err.Error(),
+		})
+		return diags
+	}
+
+	if err := syncRentContract(d, nodeID, contractID); err != nil {
+		return diag.Errorf("couldn't set rent contract data to the resource with error: %v", err)
+	}
+
+	node, err := tfPluginClient.GridProxyClient.Node(ctx, nodeID)
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("couldn't get the price of node %d", nodeID),
+			Detail:   err.Error(),
+		})
+		return diags
+	}
+	if err := d.Set("monthly_price", node.PriceUsd); err != nil {
+		return diag.Errorf("couldn't set monthly price with error: %v", err)
+	}
+
+	return diags
+}
+
+func resourceRentContractUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	// only force can change, and it's only used on delete
+	return resourceRentContractRead(ctx, d, meta)
+}
+
+func resourceRentContractDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+	sub, ok := tfPluginClient.SubstrateConn.(rentSubstrate)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("substrate client doesn't support rent contracts"))
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return diag.Errorf("couldn't parse rent contract id '%s' with error: %v", d.Id(), err)
+	}
+
+	nodeID := uint32(d.Get("node").(int))
+	nodeContracts, err := sub.GetNodeContracts(nodeID)
+	if err != nil {
+		return diag.Errorf("couldn't list contracts of node %d with error: %v", nodeID, err)
+	}
+
+	if others := otherNodeContracts(nodeContracts, contractID); len(others) != 0 {
+		if !d.Get("force").(bool) {
+			return diag.Errorf("node %d still has contracts %v, cancel them or set force to cancel them with the rent contract", nodeID, others)
+		}
+		if err := cancelContracts(tfPluginClient, others); err != nil {
+			return diag.Errorf("couldn't cancel contracts on node %d with error: %v", nodeID, err)
+		}
+	}
+
+	if err := tfPluginClient.SubstrateConn.EnsureContractCanceled(tfPluginClient.Identity, contractID); err != nil {
+		return diag.Errorf("couldn't cancel rent contract %d with error: %v", contractID, err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceRentContractImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse import id '%s', expected 'contract_id'", d.Id())
+	}
+
+	nodeID, err := loadRentContract(tfPluginClient, contractID)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't import rent contract")
+	}
+
+	if err := syncRentContract(d, nodeID, contractID); err != nil {
+		return nil, errors.Wrap(err, "couldn't set rent contract data to the resource")
+	}
+	if err := d.Set("force", false); err != nil {
+		return nil, errors.Wrap(err, "couldn't set force")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// loadRentContract makes sure the contract is a rent contract of the twin and returns its node
+func loadRentContract(tfPluginClient *deployer.TFPluginClient, contractID uint64) (uint32, error) {
+	contract, err := tfPluginClient.SubstrateConn.GetContract(contractID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldn't get contract %d", contractID)
+	}
+	if contract.IsDeleted() {
+		return 0, errors.Wrapf(substrate.ErrNotFound, "contract %d is deleted", contractID)
+	}
+	if !contract.ContractType.IsRentContract {
+		return 0, fmt.Errorf("contract %d is not a rent contract", contractID)
+	}
+	if contract.TwinID() != tfPluginClient.TwinID {
+		return 0, fmt.Errorf("contract %d is not owned by twin %d", contractID, tfPluginClient.TwinID)
+	}
+	return uint32(contract.ContractType.RentContract.Node), nil
+}
+
+// syncRentContract updates the terraform local state with the rent contract
+func syncRentContract(d *schema.ResourceData, nodeID uint32, contractID uint64) (errors error) {
+	if err := d.Set("node", nodeID); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("failed to set node with error: %w", err))
+	}
+	if err := d.Set("contract_id", int(contractID)); err != nil {
+		errors = multierror.Append(errors, fmt.Errorf("failed to set contract id with error: %w", err))
+	}
+	d.SetId(fmt.Sprint(contractID))
+	return
+}
+
+// otherNodeContracts returns the active contracts on a node besides its rent contract
+func otherNodeContracts(nodeContracts []types.U64, rentContractID uint64) []uint64 {
+	others := make([]uint64, 0)
+	for _, contractID := range nodeContracts {
+		if uint64(contractID) != rentContractID {
+			others = append(others, uint64(contractID))
+		}
+	}
+	return others
+}
+
+// cancelContracts cancels the given contracts of the twin
+func cancelContracts(tfPluginClient *deployer.TFPluginClient, contracts []uint64) (errs error) {
+	for _, contractID := range contracts {
+		if err := tfPluginClient.SubstrateConn.EnsureContractCanceled(tfPluginClient.Identity, contractID); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "couldn't cancel contract %d", contractID))
+		}
+	}
+	return
+}
@@ -0,0 +1,37 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/subi"
+)
+
+func TestOtherNodeContracts(t *testing.T) {
+	assert.Equal(t, []uint64{}, otherNodeContracts(nil, 10))
+	assert.Equal(t, []uint64{11, 12}, otherNodeContracts([]types.U64{11, 10, 12}, 10))
+}
+
+func TestSyncRentContract(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceRentContract().Schema, map[string]interface{}{})
+	assert.NoError(t, syncRentContract(d, 11, 42))
+	assert.Equal(t, "42", d.Id())
+	assert.Equal(t, 11, d.Get("node"))
+	assert.Equal(t, 42, d.Get("contract_id"))
+}
+
+func TestExtrinsicsLock(t *testing.T) {
+	sub := &subi.SubstrateImpl{}
+	lock := extrinsicsLock(sub)
+	assert.NotSame(t, &rentLock, lock)
+
+	lock.Lock()
+	assert.False(t, extrinsicsLock(sub).(*sync.Mutex).TryLock())
+	lock.Unlock()
+
+	assert.Same(t, &rentLock, extrinsicsLock(nil))
+}
@@ -4,14 +4,19 @@ package provider
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/terraform-provider-grid/internal/provider/scheduler"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 	"github.com/threefoldtech/tfgrid-sdk-go/rmb-sdk-go/peer"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 )
@@ -19,10 +24,17 @@ import (
 func resourceScheduler() *schema.Resource {
 	return &schema.Resource{
 		Description:   "Resource to dynamically assign resource requests to nodes. A user could specify their desired node configurations, and the scheduler searches the grid for eligible nodes.",
-		CreateContext: ResourceSchedCreate,
-		UpdateContext: ResourceSchedUpdate,
-		ReadContext:   ResourceSchedRead,
-		DeleteContext: ResourceSchedDelete,
+		CreateContext: withTimeout(schema.TimeoutCreate, ResourceSchedCreate),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, ResourceSchedUpdate),
+		ReadContext:   withTimeout(schema.TimeoutRead, ResourceSchedRead),
+		DeleteContext: withTimeout(schema.TimeoutDelete, ResourceSchedDelete),
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(5 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"requests": {
 				Type:        schema.TypeList,
@@ -104,6 +116,103 @@ func resourceScheduler() *schema.Resource {
 							Default:     false,
 							Description: "True to ensure this request returns a distinct node relative to this scheduler resource.",
 						},
+						"distinct_scope": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     scheduler.DistinctScopeResource,
+							Description: "Scope of `distinct`, `resource` to get nodes distinct from all other assignments of this scheduler resource, or `request` to only get nodes distinct from the other assignments of this request when `count` is set.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(
+								[]string{scheduler.DistinctScopeResource, scheduler.DistinctScopeRequest},
+								false,
+							)),
+						},
+						"count": {
+							Type:             schema.TypeInt,
+							Optional:         true,
+							Description:      "Number of nodes to assign for this request. When set, the request is expanded into `<name>-0` to `<name>-<count-1>` entries in the `nodes` dict. Resizing keeps the existing entries and only adds or drops the last ones.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+						},
+						"rented_by_me": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Use nodes already rented by the user's twin. `required` only searches rented nodes, while `preferred` searches them first then falls back to other available nodes.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(
+								[]string{scheduler.RentedByMeRequired, scheduler.RentedByMePreferred},
+								false,
+							)),
+						},
+						"network_stack": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Node networking to search for. `light` only searches zos light nodes, `full` only searches nodes with the full zos networking, and `any` searches both. If not set, light nodes are searched unless yggdrasil, wireguard or public access is requested.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(
+								[]string{scheduler.NetworkStackLight, scheduler.NetworkStackFull, scheduler.NetworkStackAny},
+								false,
+							)),
+						},
+						"mycelium": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Pick a node that supports mycelium.",
+						},
+						"public_ipv6": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Pick a node with public IPv6 access.",
+						},
+						"allow_fallback": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Search full nodes when no light node matches the request. A warning is reported when this happens.",
+						},
+						"min_uptime_percentage": {
+							Type:             schema.TypeFloat,
+							Optional:         true,
+							Description:      "Minimum percentage of the `uptime_window` the node must have been continuously up for. Must be between 0 and 100.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.FloatBetween(0, 100)),
+						},
+						"uptime_window": {
+							Type:             schema.TypeInt,
+							Optional:         true,
+							Default:          24 * 7,
+							Description:      "Window in hours over which `min_uptime_percentage` is evaluated.",
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+						},
+						"min_ipv4_bandwidth": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Minimum IPv4 upload and download bandwidth in Mbps, as measured by the node network performance tests.",
+						},
+						"min_ipv6_bandwidth": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Minimum IPv6 upload and download bandwidth in Mbps, as measured by the node network performance tests.",
+						},
+						"min_zos_version": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							Description:      "Minimum zos version running on the node (e.g. 3.11.0).",
+							ValidateDiagFunc: validation.ToDiagFunc(validateVersion),
+						},
+						"exclude_farms": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+							Description: "List of farm ids you want to exclude from the search.",
+						},
+						"include_nodes": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+							Description: "List of node ids to restrict the search to.",
+						},
 					},
 				},
 			},
@@ -113,6 +222,105 @@ func resourceScheduler() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeInt},
 				Description: "Mapping from the request name to the node id.",
 			},
+			"node_lists": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Assigned node ids of each request, in the order of the requests.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Request name.",
+						},
+						"nodes": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Elem:        &schema.Schema{Type: schema.TypeInt},
+							Description: "Node ids assigned to the request, ordered by index.",
+						},
+					},
+				},
+			},
+			"assignments": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Details of each assigned node, as they were when the node was scheduled.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Assignment name, same as its key in the `nodes` dict.",
+						},
+						"node_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Assigned node id.",
+						},
+						"farm_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Farm id of the node.",
+						},
+						"country": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Country of the node.",
+						},
+						"city": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "City of the node.",
+						},
+						"domain": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Domain of the node public config, empty if the node can't act as a name gateway.",
+						},
+						"public_ipv6": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if the node public config has an IPv6.",
+						},
+						"network_stack": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Networking stack of the node, `light` for zos light nodes, `full` otherwise.",
+						},
+						"dedicated": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if the node is dedicated (only deployable by renting it).",
+						},
+						"rented": {
+							Type:        schema.TypeBool,
+							Computed:    true,
+							Description: "True if the node is rented.",
+						},
+						"free_cru": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Free virtual CPUs of the node at scheduling time.",
+						},
+						"free_mru": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Free memory of the node at scheduling time in MBs.",
+						},
+						"free_sru": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Free SSD disk size of the node at scheduling time in MBs.",
+						},
+						"free_hru": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Free HDD disk size of the node at scheduling time in MBs.",
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -126,40 +334,219 @@ func parseAssignment(d *schema.ResourceData) map[string]uint32 {
 	return assignment
 }
 
-func parseRequests(d *schema.ResourceData, assignment map[string]uint32) []scheduler.Request {
+func parseRequests(d *schema.ResourceData, assignment map[string]uint32) ([]scheduler.Request, error) {
 	reqsIfs := d.Get("requests").([]interface{})
 	reqs := make([]scheduler.Request, 0)
+	names := map[string]bool{}
 	for _, r := range reqsIfs {
 		mp := r.(map[string]interface{})
-		if _, ok := assignment[mp["name"].(string)]; ok {
-			// skip already assigned ones
-			continue
+		name := mp["name"].(string)
+		expandedNames := expandRequestName(name, mp["count"].(int))
+
+		// nodes already assigned to this request, used to keep expanded requests distinct from each other
+		groupNodes := make([]uint32, 0)
+		for _, expandedName := range expandedNames {
+			if names[expandedName] {
+				return nil, fmt.Errorf("request name %s is used more than once", expandedName)
+			}
+			names[expandedName] = true
+			if node, ok := assignment[expandedName]; ok {
+				groupNodes = append(groupNodes, node)
+			}
 		}
-		nodesToExcludeIF := mp["node_exclude"].([]interface{})
-		nodesToExclude := make([]uint32, len(nodesToExcludeIF))
-		for idx, n := range nodesToExcludeIF {
-			nodesToExclude[idx] = uint32(n.(int))
+
+		for _, expandedName := range expandedNames {
+			if _, ok := assignment[expandedName]; ok {
+				// skip already assigned ones
+				continue
+			}
+			nodesToExclude := parseUint32List(mp["node_exclude"].([]interface{}))
+			distinctScope := mp["distinct_scope"].(string)
+			if mp["distinct"].(bool) && distinctScope == scheduler.DistinctScopeRequest {
+				nodesToExclude = append(nodesToExclude, groupNodes...)
+			}
+
+			reqs = append(reqs, scheduler.Request{
+				Name:           expandedName,
+				Group:          name,
+				FarmID:         uint32(mp["farm_id"].(int)),
+				PublicConfig:   mp["public_config"].(bool),
+				PublicIpsCount: uint32(mp["public_ips_count"].(int)),
+				Certified:      mp["certified"].(bool),
+				Dedicated:      mp["dedicated"].(bool),
+				NodeExclude:    nodesToExclude,
+				Capacity: scheduler.Capacity{
+					MRU: uint64(mp["mru"].(int)) * uint64(gridtypes.Megabyte),
+					HRU: uint64(mp["hru"].(int)) * uint64(gridtypes.Megabyte),
+					SRU: uint64(mp["sru"].(int)) * uint64(gridtypes.Megabyte),
+				},
+				Distinct:            mp["distinct"].(bool),
+				DistinctScope:       distinctScope,
+				Yggdrasil:           mp["yggdrasil"].(bool),
+				Wireguard:           mp["wireguard"].(bool),
+				RentedByMe:          mp["rented_by_me"].(string),
+				NetworkStack:        mp["network_stack"].(string),
+				Mycelium:            mp["mycelium"].(bool),
+				PublicIPv6:          mp["public_ipv6"].(bool),
+				AllowFallback:       mp["allow_fallback"].(bool),
+				MinUptimePercentage: mp["min_uptime_percentage"].(float64),
+				UptimeWindow:        time.Duration(mp["uptime_window"].(int)) * time.Hour,
+				MinIPv4Bandwidth:    uint64(mp["min_ipv4_bandwidth"].(int)),
+				MinIPv6Bandwidth:    uint64(mp["min_ipv6_bandwidth"].(int)),
+				MinZosVersion:       mp["min_zos_version"].(string),
+				ExcludeFarms:        parseUint32List(mp["exclude_farms"].([]interface{})),
+				IncludeNodes:        parseUint32List(mp["include_nodes"].([]interface{})),
+			})
 		}
+	}
+	return reqs, nil
+}
 
-		reqs = append(reqs, scheduler.Request{
-			Name:           mp["name"].(string),
-			FarmID:         uint32(mp["farm_id"].(int)),
-			PublicConfig:   mp["public_config"].(bool),
-			PublicIpsCount: uint32(mp["public_ips_count"].(int)),
-			Certified:      mp["certified"].(bool),
-			Dedicated:      mp["dedicated"].(bool),
-			NodeExclude:    nodesToExclude,
-			Capacity: scheduler.Capacity{
-				MRU: uint64(mp["mru"].(int)) * uint64(gridtypes.Megabyte),
-				HRU: uint64(mp["hru"].(int)) * uint64(gridtypes.Megabyte),
-				SRU: uint64(mp["sru"].(int)) * uint64(gridtypes.Megabyte),
-			},
-			Distinct:  mp["distinct"].(bool),
-			Yggdrasil: mp["yggdrasil"].(bool),
-			Wireguard: mp["wireguard"].(bool),
+// expandRequestName returns the assignment names of a request, a request without count has a single assignment named after it
+func expandRequestName(name string, count int) []string {
+	if count == 0 {
+		return []string{name}
+	}
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", name, i)
+	}
+	return names
+}
+
+// resizeAssignment keeps the assignment in sync with the requests count. Existing indexes keep their nodes,
+// assignments beyond the request count are dropped, and switching between a single node and a counted request
+// moves the node between `name` and `name-0`.
+func resizeAssignment(d *schema.ResourceData, assignment map[string]uint32) {
+	for _, r := range d.Get("requests").([]interface{}) {
+		mp := r.(map[string]interface{})
+		name := mp["name"].(string)
+		count := mp["count"].(int)
+
+		first := fmt.Sprintf("%s-0", name)
+		if count == 0 {
+			if _, ok := assignment[name]; !ok {
+				if node, ok := assignment[first]; ok {
+					assignment[name] = node
+				}
+			}
+		} else {
+			if _, ok := assignment[first]; !ok {
+				if node, ok := assignment[name]; ok {
+					assignment[first] = node
+				}
+			}
+			delete(assignment, name)
+		}
+
+		for idx := max(count, 0); ; idx++ {
+			key := fmt.Sprintf("%s-%d", name, idx)
+			if _, ok := assignment[key]; !ok {
+				break
+			}
+			delete(assignment, key)
+		}
+	}
+}
+
+// nodeLists lists the name and the assigned nodes, ordered by index, of each request in the order of the requests
+func nodeLists(d *schema.ResourceData, assignment map[string]uint32) []interface{} {
+	lists := make([]interface{}, 0)
+	for _, r := range d.Get("requests").([]interface{}) {
+		mp := r.(map[string]interface{})
+		name := mp["name"].(string)
+
+		nodes := make([]interface{}, 0)
+		for _, expandedName := range expandRequestName(name, mp["count"].(int)) {
+			if node, ok := assignment[expandedName]; ok {
+				nodes = append(nodes, int(node))
+			}
+		}
+		lists = append(lists, map[string]interface{}{
+			"name":  name,
+			"nodes": nodes,
 		})
 	}
-	return reqs
+	return lists
+}
+
+// assignmentDetails lists the details of each assigned node. Details of nodes assigned in previous runs are kept
+// as they were at their scheduling time, while newly assigned nodes are looked up.
+func assignmentDetails(ctx context.Context, d *schema.ResourceData, s *scheduler.Scheduler, assignment map[string]uint32) ([]interface{}, error) {
+	previous := make(map[string]map[string]interface{})
+	for _, a := range d.Get("assignments").([]interface{}) {
+		mp := a.(map[string]interface{})
+		previous[mp["name"].(string)] = mp
+	}
+
+	names := make([]string, 0, len(assignment))
+	for name := range assignment {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	details := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		nodeID := assignment[name]
+		if mp, ok := previous[name]; ok && uint32(mp["node_id"].(int)) == nodeID {
+			details = append(details, mp)
+			continue
+		}
+
+		node, err := s.NodeDetails(ctx, nodeID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't get details of node %d assigned to %s", nodeID, name)
+		}
+		details = append(details, flattenAssignment(name, node))
+	}
+	return details, nil
+}
+
+func flattenAssignment(name string, node proxyTypes.Node) map[string]interface{} {
+	networkStack := "full"
+	if slices.Contains(node.Features, zos.NetworkLightType) {
+		networkStack = "light"
+	}
+
+	return map[string]interface{}{
+		"name":          name,
+		"node_id":       node.NodeID,
+		"farm_id":       node.FarmID,
+		"country":       node.Location.Country,
+		"city":          node.Location.City,
+		"domain":        node.PublicConfig.Domain,
+		"public_ipv6":   node.PublicConfig.Ipv6 != "",
+		"network_stack": networkStack,
+		"dedicated":     node.Dedicated,
+		"rented":        node.Rented,
+		"free_cru":      int(freeCapacity(node.TotalResources.CRU, node.UsedResources.CRU)),
+		"free_mru":      int(freeCapacity(node.TotalResources.MRU, node.UsedResources.MRU) / gridtypes.Megabyte),
+		"free_sru":      int(freeCapacity(node.TotalResources.SRU, node.UsedResources.SRU) / gridtypes.Megabyte),
+		"free_hru":      int(freeCapacity(node.TotalResources.HRU, node.UsedResources.HRU) / gridtypes.Megabyte),
+	}
+}
+
+// freeCapacity is the capacity left on a node, zero for overcommitted nodes that use more than their total
+func freeCapacity[T ~uint64](total, used T) T {
+	if used >= total {
+		return 0
+	}
+	return total - used
+}
+
+func parseUint32List(listIf []interface{}) []uint32 {
+	list := make([]uint32, len(listIf))
+	for idx, v := range listIf {
+		list[idx] = uint32(v.(int))
+	}
+	return list
+}
+
+func validateVersion(i interface{}, k string) (warnings []string, errs []error) {
+	if _, err := version.NewVersion(i.(string)); err != nil {
+		errs = append(errs, errors.Wrapf(err, "expected %s to be a valid version", k))
+	}
+	return
 }
 
 func schedule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -169,7 +556,11 @@ func schedule(ctx context.Context, d *schema.ResourceData, meta interface{}) dia
 	}
 	// read previously assigned nodes
 	assignment := parseAssignment(d)
-	reqs := parseRequests(d, assignment)
+	resizeAssignment(d, assignment)
+	reqs, err := parseRequests(d, assignment)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
 	rpcClient, ok := tfPluginClient.RMB.(*peer.RpcClient)
 	if !ok {
@@ -181,12 +572,35 @@ func schedule(ctx context.Context, d *schema.ResourceData, meta interface{}) dia
 		return diag.FromErr(err)
 	}
 
-	err := d.Set("nodes", assignment)
+	var diags diag.Diagnostics
+	for _, name := range scheduler.Fallbacks() {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("request %s is scheduled on a full node", name),
+			Detail:   "no light node matched the request, so full nodes were searched as allowed by allow_fallback",
+		})
+	}
+
+	err = d.Set("nodes", assignment)
 	if err != nil {
 		return diag.FromErr(errors.Wrapf(err, "couldn't set nodes with %v", assignment))
 	}
-	return nil
 
+	lists := nodeLists(d, assignment)
+	err = d.Set("node_lists", lists)
+	if err != nil {
+		return diag.FromErr(errors.Wrapf(err, "couldn't set node lists with %v", lists))
+	}
+
+	details, err := assignmentDetails(ctx, d, &scheduler, assignment)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = d.Set("assignments", details)
+	if err != nil {
+		return diag.FromErr(errors.Wrap(err, "couldn't set assignments"))
+	}
+	return diags
 }
 
 // ResourceSchedRead reads for schedule resource
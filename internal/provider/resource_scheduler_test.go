@@ -0,0 +1,91 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+func schedulerResourceData(t *testing.T, count int) *schema.ResourceData {
+	return schema.TestResourceDataRaw(t, resourceScheduler().Schema, map[string]interface{}{
+		"requests": []interface{}{
+			map[string]interface{}{
+				"name":      "workers",
+				"count":     count,
+				"yggdrasil": false,
+				"wireguard": false,
+			},
+		},
+	})
+}
+
+func TestResizeAssignment(t *testing.T) {
+	assignment := map[string]uint32{"workers-0": 1, "workers-1": 2, "workers-2": 3, "other": 4}
+
+	resizeAssignment(schedulerResourceData(t, 2), assignment)
+	assert.Equal(t, map[string]uint32{"workers-0": 1, "workers-1": 2, "other": 4}, assignment, "the tail should be dropped")
+
+	resizeAssignment(schedulerResourceData(t, 4), assignment)
+	assert.Equal(t, map[string]uint32{"workers-0": 1, "workers-1": 2, "other": 4}, assignment, "existing indexes should be kept")
+
+	resizeAssignment(schedulerResourceData(t, 0), assignment)
+	assert.Equal(t, map[string]uint32{"workers": 1, "other": 4}, assignment, "the first node should be kept without count")
+
+	resizeAssignment(schedulerResourceData(t, 3), assignment)
+	assert.Equal(t, map[string]uint32{"workers-0": 1, "other": 4}, assignment, "the single node should become the first one")
+}
+
+func TestParseRequestsCount(t *testing.T) {
+	d := schedulerResourceData(t, 3)
+	reqs, err := parseRequests(d, map[string]uint32{"workers-1": 5})
+	assert.NoError(t, err)
+	assert.Len(t, reqs, 2)
+	assert.Equal(t, "workers-0", reqs[0].Name)
+	assert.Equal(t, "workers-2", reqs[1].Name)
+	assert.Equal(t, "workers", reqs[1].Group)
+
+	lists := nodeLists(d, map[string]uint32{"workers-0": 3, "workers-1": 5, "workers-2": 1})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "workers", "nodes": []interface{}{3, 5, 1}},
+	}, lists)
+}
+
+func TestFlattenAssignment(t *testing.T) {
+	node := proxyTypes.Node{
+		NodeID:   11,
+		FarmID:   1,
+		Features: []string{zos.NetworkLightType, zos.ZMachineLightType},
+		Location: proxyTypes.Location{
+			Country: "Belgium",
+			City:    "Ghent",
+		},
+		PublicConfig: proxyTypes.PublicConfig{
+			Domain: "gent01.dev.grid.tf",
+		},
+		TotalResources: proxyTypes.Capacity{
+			CRU: 8,
+			MRU: 4 * gridtypes.Gigabyte,
+		},
+		UsedResources: proxyTypes.Capacity{
+			CRU: 2,
+			MRU: 1 * gridtypes.Gigabyte,
+		},
+	}
+	mp := flattenAssignment("gw", node)
+	assert.Equal(t, "gw", mp["name"])
+	assert.Equal(t, 11, mp["node_id"])
+	assert.Equal(t, "gent01.dev.grid.tf", mp["domain"])
+	assert.Equal(t, false, mp["public_ipv6"])
+	assert.Equal(t, "light", mp["network_stack"])
+	assert.Equal(t, 6, mp["free_cru"])
+	assert.Equal(t, 3*1024, mp["free_mru"])
+
+	node.UsedResources.CRU = 10
+	mp = flattenAssignment("gw", node)
+	assert.Equal(t, 0, mp["free_cru"], "overcommitted nodes have no free capacity")
+}
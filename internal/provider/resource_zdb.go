@@ -0,0 +1,281 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func resourceZDB() *schema.Resource {
+	return &schema.Resource{
+		// This description is used by the documentation generator and the language server.
+		Description:   "Resource for deploying a ZDB namespace on its own node contract, independent of any vm. You can read more about 0-db (ZDB) [here](https://github.com/threefoldtech/0-db/).",
+		CreateContext: withTimeout(schema.TimeoutCreate, resourceZDBCreate),
+		ReadContext:   withTimeout(schema.TimeoutRead, resourceZDBRead),
+		UpdateContext: withTimeout(schema.TimeoutUpdate, resourceZDBUpdate),
+		DeleteContext: withTimeout(schema.TimeoutDelete, resourceZDBDelete),
+		CustomizeDiff: resourceZDBCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceZDBImport,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(10 * time.Minute),
+			Update: schema.DefaultTimeout(10 * time.Minute),
+			Delete: schema.DefaultTimeout(10 * time.Minute),
+			Read:   schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:             schema.TypeString,
+				Required:         true,
+				ForceNew:         true,
+				Description:      "ZDB workload name, also used as the solution name of its contract. Must contain only alphanumeric and underscore characters.",
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringMatch(regexp.MustCompile(nameValidationRegex), nameValidationErrorMessage)),
+			},
+			"node": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Node id to place the zdb on.",
+			},
+			"solution_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "Solution type for created contract to be consistent across threefold tooling. Defaults to `zdb/<name>`.",
+			},
+			"size": {
+				Type:             schema.TypeInt,
+				Required:         true,
+				Description:      "Size of the ZDB in GBs. It can be increased in place, but not decreased.",
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
+			},
+			"mode": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ForceNew:         true,
+				Default:          workloads.ZDBModeUser,
+				Description:      "Mode of the ZDB, `user` or `seq`. `user` is the default mode where a user can SET their own keys, like any key-value store. All keys are kept in memory. in `seq` mode, keys are sequential and autoincremented.",
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice([]string{workloads.ZDBModeUser, workloads.ZDBModeSeq}, false)),
+			},
+			"public": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     false,
+				Description: "Makes it read-only if password is set, writable if no password set.",
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Default:     "",
+				Description: "ZDB namespace password, it can be rotated in place.",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "ZDB workload description.",
+			},
+			"ips": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed:    true,
+				Description: "Computed IPs of the ZDB. Two IPs are returned: a public IPv6, and a YggIP, in this order",
+			},
+			"namespace": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Namespace of the ZDB.",
+			},
+			"port": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Port of the ZDB.",
+			},
+		},
+	}
+}
+
+// resourceZDBCustomizeDiff refuses to shrink a zdb, the namespace could lose the data above the new size
+func resourceZDBCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.HasChange("size") {
+		return nil
+	}
+	oldSize, newSize := d.GetChange("size")
+	if newSize.(int) < oldSize.(int) {
+		return fmt.Errorf("size: can't shrink from %d to %d GBs, zdbs can only grow in place", oldSize, newSize)
+	}
+	return nil
+}
+
+func resourceZDBCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	dl, err := newZDBDeploymentFromSchema(d)
+	if err != nil {
+		return diag.Errorf("couldn't load zdb data with error: %v", err)
+	}
+
+	if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
+		diags := diag.Errorf("couldn't deploy zdb with error: %v", err)
+		// failed to deploy and failed to revert, store the contract that is still valid so the tainted zdb gets cleaned up
+		return append(diags, storePartialZDB(ctx, d, tfPluginClient, dl)...)
+	}
+
+	return storeZDB(ctx, d, tfPluginClient, dl)
+}
+
+func resourceZDBUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	dl, err := newZDBDeploymentFromSchema(d)
+	if err != nil {
+		return diag.Errorf("couldn't load zdb data with error: %v", err)
+	}
+
+	if err := tfPluginClient.DeploymentDeployer.Deploy(ctx, dl); err != nil {
+		diags := diag.Errorf("couldn't update zdb with error: %v", err)
+		return append(diags, storePartialZDB(ctx, d, tfPluginClient, dl)...)
+	}
+
+	return storeZDB(ctx, d, tfPluginClient, dl)
+}
+
+func resourceZDBRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	dl, err := newZDBDeploymentFromSchema(d)
+	if err != nil {
+		return diag.Errorf("couldn't load zdb data with error: %v", err)
+	}
+
+	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "failed to read deployment data (terraform refresh might help)",
+			Detail:   err.Error(),
+		})
+		return diags
+	}
+
+	if dl.ContractID == 0 {
+		// the contract was canceled outside of terraform
+		d.SetId("")
+		return diags
+	}
+
+	if err := syncContractsZDB(d, dl); err != nil {
+		return diag.Errorf("couldn't set zdb data to the resource with error: %v", err)
+	}
+
+	return diags
+}
+
+func resourceZDBDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("failed to cast meta into threefold plugin client"))
+	}
+
+	dl, err := newZDBDeploymentFromSchema(d)
+	if err != nil {
+		return diag.Errorf("couldn't load zdb data with error: %v", err)
+	}
+
+	if err := tfPluginClient.DeploymentDeployer.Cancel(ctx, dl); err != nil {
+		return diag.Errorf("couldn't cancel zdb with error: %v", err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceZDBImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	tfPluginClient, ok := meta.(*deployer.TFPluginClient)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast meta into threefold plugin client")
+	}
+
+	contractID, err := strconv.ParseUint(d.Id(), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse import id '%s', expected 'contract_id'", d.Id())
+	}
+
+	dl, err := loadDeploymentFromContract(ctx, tfPluginClient, 0, contractID)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't import zdb")
+	}
+
+	if _, only := deploymentZDB(dl); !only {
+		return nil, fmt.Errorf("contract %d doesn't hold a single zdb, import it as a grid_deployment instead", contractID)
+	}
+
+	if err := syncContractsZDB(d, dl); err != nil {
+		return nil, errors.Wrap(err, "couldn't set zdb data to the resource")
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// storeZDB syncs the zdb from its node and stores it
+func storeZDB(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, dl *workloads.Deployment) diag.Diagnostics {
+	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+		return diag.Errorf("couldn't sync zdb with error: %v", err)
+	}
+
+	if err := syncContractsZDB(d, dl); err != nil {
+		return diag.Errorf("couldn't set zdb data to the resource with error: %v", err)
+	}
+
+	if zdb, _ := deploymentZDB(dl); zdb == nil {
+		return diag.Errorf("zdb %s is not ready on node %d", d.Get("name"), dl.NodeID)
+	}
+	return nil
+}
+
+// storePartialZDB syncs the contract a failed deployment left behind, and stores it if it's still valid
+func storePartialZDB(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, dl *workloads.Deployment) diag.Diagnostics {
+	var diags diag.Diagnostics
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	dl.ContractID = dl.NodeDeploymentID[dl.NodeID]
+	if err := tfPluginClient.DeploymentDeployer.Sync(ctx, dl); err != nil {
+		// the contract is stored unsynced rather than orphaned
+		diags = diag.Errorf("couldn't sync zdb with error: %v", err)
+	}
+	if dl.ContractID == 0 {
+		return diags
+	}
+
+	if err := syncContractsZDB(d, dl); err != nil {
+		diags = append(diags, diag.Errorf("couldn't set zdb data to the resource with error: %v", err)...)
+	}
+	return diags
+}
@@ -0,0 +1,146 @@
+// Package scheduler provides a simple scheduler interface to request deployments on nodes.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+const (
+	ZosVersionAction = "zos.system.version"
+	ZosPerfAction    = "zos.perf.get"
+	iperfTestName    = "iperf"
+	mbps             = 1000 * 1000
+
+	// myceliumMinZosVersion is the first zos version supporting mycelium on full nodes, light nodes always support it
+	myceliumMinZosVersion = "3.10.0"
+)
+
+// nodeQuality holds node information that is not indexed by the grid proxy and has to be fetched from the node
+type nodeQuality struct {
+	zosVersion    string
+	ipv6Bandwidth float64 // in Mbps, the minimum of upload and download speeds
+}
+
+type zosVersion struct {
+	ZOS   string `json:"zos"`
+	ZInit string `json:"zinit"`
+}
+
+type perfTestResult struct {
+	Name   string      `json:"name"`
+	Result interface{} `json:"result"`
+}
+
+type iperfResult struct {
+	UploadSpeed   float64 `json:"upload_speed"`   // in bit/sec
+	DownloadSpeed float64 `json:"download_speed"` // in bit/sec
+	NodeIP        string  `json:"node_ip"`
+	TestType      string  `json:"test_type"`
+}
+
+func (n *Scheduler) getNodeQuality(ctx context.Context, node proxyTypes.Node, r *Request) (nodeQuality, error) {
+	var quality nodeQuality
+	twin := uint32(node.TwinID)
+
+	if r.MinZosVersion != "" || (r.Mycelium && !isLight(node)) {
+		var ver zosVersion
+		if err := n.rmbClient.CallWithSession(ctx, twin, nil, ZosVersionAction, nil, &ver); err != nil {
+			return quality, errors.Wrapf(err, "failed to get zos version of node %d", node.NodeID)
+		}
+		quality.zosVersion = ver.ZOS
+	}
+
+	if r.MinIPv6Bandwidth != 0 {
+		payload := struct {
+			Name string
+		}{
+			Name: iperfTestName,
+		}
+		var res perfTestResult
+		if err := n.rmbClient.CallWithSession(ctx, twin, nil, ZosPerfAction, payload, &res); err != nil {
+			return quality, errors.Wrapf(err, "failed to get iperf results of node %d", node.NodeID)
+		}
+
+		bandwidth, err := parseIPv6Bandwidth(res)
+		if err != nil {
+			return quality, errors.Wrapf(err, "failed to parse iperf results of node %d", node.NodeID)
+		}
+		quality.ipv6Bandwidth = bandwidth
+	}
+
+	return quality, nil
+}
+
+// parseIPv6Bandwidth picks the first successful tcp report made over IPv6, similar to what the grid proxy does for IPv4
+func parseIPv6Bandwidth(res perfTestResult) (float64, error) {
+	data, err := json.Marshal(res.Result)
+	if err != nil {
+		return 0, err
+	}
+
+	var reports []iperfResult
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return 0, err
+	}
+
+	for _, report := range reports {
+		ip := net.ParseIP(report.NodeIP)
+		if ip == nil || ip.To4() != nil || report.TestType != "tcp" || report.DownloadSpeed == 0 {
+			continue
+		}
+		return min(report.UploadSpeed, report.DownloadSpeed) / mbps, nil
+	}
+
+	return 0, nil
+}
+
+func isLight(node proxyTypes.Node) bool {
+	return slices.Contains(node.Features, zos.NetworkLightType)
+}
+
+// supportsMycelium reports whether the node could give workloads mycelium IPs
+func (node *nodeInfo) supportsMycelium() bool {
+	if isLight(node.Node) {
+		return true
+	}
+	return node.quality != nil && versionAtLeast(node.quality.zosVersion, myceliumMinZosVersion)
+}
+
+// uptimePercentage returns the percentage of the window the node has been continuously up for
+func uptimePercentage(node proxyTypes.Node, window time.Duration) float64 {
+	if window <= 0 {
+		return 100
+	}
+	uptime := time.Duration(node.Uptime) * time.Second
+	if uptime > window {
+		uptime = window
+	}
+	return float64(uptime) / float64(window) * 100
+}
+
+// ipv4Bandwidth returns the minimum of the upload and download speeds indexed by the grid proxy in Mbps
+func ipv4Bandwidth(node proxyTypes.Node) float64 {
+	return min(node.Speed.Upload, node.Speed.Download) / mbps
+}
+
+// versionAtLeast reports whether the given zos version is greater than or equal to the minimum version
+func versionAtLeast(current, minimum string) bool {
+	cur, err := version.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	minVer, err := version.NewVersion(minimum)
+	if err != nil {
+		return false
+	}
+	return cur.GreaterThanOrEqual(minVer)
+}
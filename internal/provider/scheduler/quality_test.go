@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
+)
+
+func TestUptimePercentage(t *testing.T) {
+	node := proxyTypes.Node{
+		Uptime: int64((6 * time.Hour).Seconds()),
+	}
+	assert.Equal(t, uptimePercentage(node, 24*time.Hour), float64(25))
+	assert.Equal(t, uptimePercentage(node, 6*time.Hour), float64(100))
+	assert.Equal(t, uptimePercentage(node, time.Hour), float64(100))
+}
+
+func TestParseIPv6Bandwidth(t *testing.T) {
+	res := perfTestResult{
+		Name: iperfTestName,
+		Result: []interface{}{
+			map[string]interface{}{"node_ip": "1.2.3.4", "test_type": "tcp", "upload_speed": 900 * mbps, "download_speed": 900 * mbps},
+			map[string]interface{}{"node_ip": "2a02:1802:5e::1", "test_type": "udp", "upload_speed": 800 * mbps, "download_speed": 800 * mbps},
+			map[string]interface{}{"node_ip": "2a02:1802:5e::1", "test_type": "tcp", "upload_speed": 300 * mbps, "download_speed": 400 * mbps},
+		},
+	}
+	bandwidth, err := parseIPv6Bandwidth(res)
+	assert.NoError(t, err)
+	assert.Equal(t, bandwidth, float64(300))
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, versionAtLeast("v3.11.2", "3.11.0"))
+	assert.True(t, versionAtLeast("3.11.0", "v3.11.0"))
+	assert.False(t, versionAtLeast("v3.10.9", "3.11"))
+	assert.False(t, versionAtLeast("unknown", "3.11"))
+}
@@ -2,6 +2,9 @@
 package scheduler
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
 	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 )
@@ -11,10 +14,30 @@ var (
 	trueVal  = true
 )
 
+const (
+	// RentedByMeRequired restricts the search to nodes rented by the twin
+	RentedByMeRequired = "required"
+	// RentedByMePreferred searches nodes rented by the twin first, then falls back to other available nodes
+	RentedByMePreferred = "preferred"
+
+	// DistinctScopeResource makes a distinct request get a node not assigned to any other request
+	DistinctScopeResource = "resource"
+	// DistinctScopeRequest makes a distinct request get a node not assigned to any other request of the same group
+	DistinctScopeRequest = "request"
+
+	// NetworkStackLight picks only zos light nodes
+	NetworkStackLight = "light"
+	// NetworkStackFull picks only nodes running the full zos networking
+	NetworkStackFull = "full"
+	// NetworkStackAny picks nodes regardless of their networking
+	NetworkStackAny = "any"
+)
+
 // Request struct for requesting a capacity
 type Request struct {
 	Capacity       Capacity
 	Name           string
+	Group          string // name of the request this one was expanded from, defaults to Name
 	FarmID         uint32
 	PublicConfig   bool
 	PublicIpsCount uint32
@@ -22,8 +45,68 @@ type Request struct {
 	Dedicated      bool
 	NodeExclude    []uint32
 	Distinct       bool
+	DistinctScope  string
 	Yggdrasil      bool
 	Wireguard      bool
+	RentedByMe     string
+
+	// network requirements, an empty network stack is derived from the other requirements
+	NetworkStack  string
+	Mycelium      bool
+	PublicIPv6    bool
+	AllowFallback bool // allows widening a light network stack to full nodes when no light node matches
+
+	// node quality thresholds, zero values disable the check
+	MinUptimePercentage float64
+	UptimeWindow        time.Duration
+	MinIPv4Bandwidth    uint64 // in Mbps
+	MinIPv6Bandwidth    uint64 // in Mbps
+	MinZosVersion       string
+	ExcludeFarms        []uint32
+	IncludeNodes        []uint32
+}
+
+// needsNodeQuality reports whether the request has thresholds that can only be checked by asking the node itself
+func (r *Request) needsNodeQuality() bool {
+	return r.MinZosVersion != "" || r.MinIPv6Bandwidth != 0 || r.Mycelium
+}
+
+// needsGridProxy reports whether the request has requirements that the farmer bot can't evaluate
+func (r *Request) needsGridProxy() bool {
+	return r.MinUptimePercentage != 0 ||
+		r.MinIPv4Bandwidth != 0 ||
+		len(r.ExcludeFarms) != 0 ||
+		len(r.IncludeNodes) != 0 ||
+		r.NetworkStack != "" ||
+		r.PublicIPv6 ||
+		r.needsNodeQuality()
+}
+
+// requiresFullNetworking reports whether the request needs features only available with the full zos networking
+func (r *Request) requiresFullNetworking() bool {
+	return r.Yggdrasil || r.Wireguard
+}
+
+// networkStack resolves the network stack to search, a request without an explicit one prefers light nodes
+// unless it needs public access or features only available on full nodes
+func (r *Request) networkStack() string {
+	if r.NetworkStack == NetworkStackLight || r.NetworkStack == NetworkStackFull {
+		return r.NetworkStack
+	}
+	if r.requiresFullNetworking() || r.PublicConfig || r.PublicIpsCount != 0 {
+		return NetworkStackFull
+	}
+	if r.NetworkStack == NetworkStackAny {
+		return NetworkStackAny
+	}
+	return NetworkStackLight
+}
+
+func (r *Request) validate() error {
+	if r.NetworkStack == NetworkStackLight && r.requiresFullNetworking() {
+		return fmt.Errorf("yggdrasil and wireguard are not supported on the %s network stack", NetworkStackLight)
+	}
+	return nil
 }
 
 func (r *Request) constructFilter(twinID uint64) (f proxyTypes.NodeFilter) {
@@ -54,12 +137,28 @@ func (r *Request) constructFilter(twinID uint64) (f proxyTypes.NodeFilter) {
 	if r.Dedicated {
 		f.Rentable = &trueVal
 	}
-
-	if r.Yggdrasil || r.Wireguard || r.PublicConfig || r.PublicIpsCount != 0 {
-		f.Features = []string{zos.NetworkType, zos.ZMachineType}
-	} else {
-		f.Features = []string{zos.NetworkLightType, zos.ZMachineLightType}
+	if r.RentedByMe == RentedByMeRequired {
+		f.RentedBy = &twinID
+	}
+	for _, node := range r.IncludeNodes {
+		f.NodeIDs = append(f.NodeIDs, uint64(node))
 	}
+	if r.PublicIPv6 {
+		f.HasIpv6 = &trueVal
+	}
+
+	f.Features = r.features()
 
 	return f
 }
+
+// features are the node features required by the network stack of the request
+func (r *Request) features() []string {
+	switch r.networkStack() {
+	case NetworkStackFull:
+		return []string{zos.NetworkType, zos.ZMachineType}
+	case NetworkStackLight:
+		return []string{zos.NetworkLightType, zos.ZMachineLightType}
+	}
+	return nil
+}
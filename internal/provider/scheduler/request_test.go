@@ -3,8 +3,10 @@ package scheduler
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 )
 
@@ -13,7 +15,8 @@ func TestFulfilsSuccess(t *testing.T) {
 	nodeInfo := nodeInfo{
 		FreeCapacity: &cap,
 		Node: types.Node{
-			FarmID: 1,
+			FarmID:   1,
+			Features: anyStackFeatures,
 			PublicConfig: types.PublicConfig{
 				Ipv4:   "1.2.3.4",
 				Domain: "example.com",
@@ -32,7 +35,7 @@ func TestFulfilsSuccess(t *testing.T) {
 		FarmID:         1,
 		PublicIpsCount: 1,
 		PublicConfig:   false,
-	}, farm), true, "fullfil-success")
+	}, farm, 1), true, "fullfil-success")
 }
 
 func TestFulfilsFail(t *testing.T) {
@@ -40,7 +43,8 @@ func TestFulfilsFail(t *testing.T) {
 	nodeInfo := nodeInfo{
 		FreeCapacity: &cap,
 		Node: types.Node{
-			FarmID: 1,
+			FarmID:   1,
+			Features: anyStackFeatures,
 			PublicConfig: types.PublicConfig{
 				Ipv4:   "",
 				Domain: "",
@@ -60,7 +64,7 @@ func TestFulfilsFail(t *testing.T) {
 	farmInfo := farmInfo{
 		freeIPs: 1,
 	}
-	assert.Equal(t, nodeInfo.fulfils(&req, farmInfo), true, "this request should be successful")
+	assert.Equal(t, nodeInfo.fulfils(&req, farmInfo, 1), true, "this request should be successful")
 
 	violations := map[string]func(r *Request){
 		"mru":              func(r *Request) { r.Capacity.MRU = 4 },
@@ -69,12 +73,13 @@ func TestFulfilsFail(t *testing.T) {
 		"farm_id":          func(r *Request) { r.FarmID = 2 },
 		"public_ips_count": func(r *Request) { r.PublicIpsCount = 3 },
 		"public_config":    func(r *Request) { r.PublicConfig = true },
+		"public_ipv6":      func(r *Request) { r.PublicIPv6 = true },
 	}
 	for key, fn := range violations {
 		cp := req
 		fn(&cp)
 
-		assert.Equal(t, nodeInfo.fulfils(&cp, farmInfo), false, fmt.Sprintf("fullfil-fail-%s", key))
+		assert.Equal(t, nodeInfo.fulfils(&cp, farmInfo, 1), false, fmt.Sprintf("fullfil-fail-%s", key))
 	}
 }
 
@@ -106,5 +111,124 @@ func TestConstructFilter(t *testing.T) {
 	assert.Empty(t, con.Domain, "construct-filter-domain")
 	assert.Empty(t, con.Rentable, "construct-filter-rentable")
 	assert.Empty(t, con.RentedBy, "construct-filter-rented-by")
+	assert.Empty(t, con.NodeIDs, "construct-filter-node-ids")
 	assert.Equal(t, *con.AvailableFor, uint64(1), "construct-filter-available-for")
+
+	r.IncludeNodes = []uint32{4, 5}
+	con = r.constructFilter(1)
+	assert.Equal(t, con.NodeIDs, []uint64{4, 5}, "construct-filter-node-ids")
+
+	r.RentedByMe = RentedByMePreferred
+	con = r.constructFilter(1)
+	assert.Empty(t, con.RentedBy, "construct-filter-rented-by")
+
+	r.RentedByMe = RentedByMeRequired
+	con = r.constructFilter(1)
+	assert.Equal(t, *con.RentedBy, uint64(1), "construct-filter-rented-by")
+}
+
+func TestConstructFilterNetworkStack(t *testing.T) {
+	r := Request{}
+	con := r.constructFilter(1)
+	assert.Equal(t, con.Features, []string{zos.NetworkLightType, zos.ZMachineLightType}, "construct-filter-default-light")
+	assert.Empty(t, con.HasIpv6, "construct-filter-has-ipv6")
+
+	r.NetworkStack = NetworkStackAny
+	con = r.constructFilter(1)
+	assert.Empty(t, con.Features, "construct-filter-any")
+
+	r.Wireguard = true
+	con = r.constructFilter(1)
+	assert.Equal(t, con.Features, []string{zos.NetworkType, zos.ZMachineType}, "construct-filter-wireguard-full")
+
+	r = Request{NetworkStack: NetworkStackFull, PublicIPv6: true}
+	con = r.constructFilter(1)
+	assert.Equal(t, con.Features, []string{zos.NetworkType, zos.ZMachineType}, "construct-filter-full")
+	assert.Equal(t, *con.HasIpv6, true, "construct-filter-has-ipv6")
+}
+
+func TestFulfilsQualityThresholds(t *testing.T) {
+	cap := freeCapacity(&node)
+	nodeInfo := nodeInfo{
+		FreeCapacity: &cap,
+		Node: types.Node{
+			NodeID:   1,
+			FarmID:   1,
+			Features: anyStackFeatures,
+			Uptime:   int64((12 * time.Hour).Seconds()),
+			Speed: types.Speed{
+				Upload:   200 * mbps,
+				Download: 500 * mbps,
+			},
+		},
+		quality: &nodeQuality{
+			zosVersion:    "v3.11.2",
+			ipv6Bandwidth: 100,
+		},
+	}
+	req := Request{
+		MinUptimePercentage: 50,
+		UptimeWindow:        24 * time.Hour,
+		MinIPv4Bandwidth:    200,
+		MinIPv6Bandwidth:    100,
+		MinZosVersion:       "3.11.0",
+		ExcludeFarms:        []uint32{2},
+		IncludeNodes:        []uint32{1, 2},
+	}
+	farm := farmInfo{}
+	assert.Equal(t, nodeInfo.fulfils(&req, farm, 1), true, "this request should be successful")
+
+	violations := map[string]func(r *Request){
+		"min_uptime_percentage": func(r *Request) { r.MinUptimePercentage = 60 },
+		"uptime_window":         func(r *Request) { r.UptimeWindow = 48 * time.Hour },
+		"min_ipv4_bandwidth":    func(r *Request) { r.MinIPv4Bandwidth = 300 },
+		"min_ipv6_bandwidth":    func(r *Request) { r.MinIPv6Bandwidth = 101 },
+		"min_zos_version":       func(r *Request) { r.MinZosVersion = "3.12" },
+		"exclude_farms":         func(r *Request) { r.ExcludeFarms = []uint32{1} },
+		"include_nodes":         func(r *Request) { r.IncludeNodes = []uint32{2} },
+	}
+	for key, fn := range violations {
+		cp := req
+		fn(&cp)
+
+		assert.Equal(t, nodeInfo.fulfils(&cp, farm, 1), false, fmt.Sprintf("fullfil-fail-%s", key))
+	}
+
+	nodeInfo.quality = nil
+	assert.Equal(t, nodeInfo.fulfils(&req, farm, 1), false, "node quality should be fetched before checking")
+}
+
+func TestFulfilsMycelium(t *testing.T) {
+	cap := freeCapacity(&node)
+	nodeInfo := nodeInfo{
+		FreeCapacity: &cap,
+		Node:         types.Node{Features: []string{zos.NetworkType, zos.ZMachineType}},
+		quality:      &nodeQuality{zosVersion: "v3.9.0"},
+	}
+	req := Request{Mycelium: true, NetworkStack: NetworkStackAny}
+	farm := farmInfo{}
+	assert.Equal(t, nodeInfo.fulfils(&req, farm, 1), false, "full node with an old zos shouldn't support mycelium")
+
+	nodeInfo.quality.zosVersion = "v3.10.0"
+	assert.Equal(t, nodeInfo.fulfils(&req, farm, 1), true, "full node with a recent zos should support mycelium")
+
+	nodeInfo.Node.Features = []string{zos.NetworkLightType, zos.ZMachineLightType}
+	nodeInfo.quality.zosVersion = ""
+	assert.Equal(t, nodeInfo.fulfils(&req, farm, 1), true, "light nodes always support mycelium")
+}
+
+func TestFulfilsNetworkStack(t *testing.T) {
+	cap := freeCapacity(&node)
+	nodeInfo := nodeInfo{
+		FreeCapacity: &cap,
+		Node:         types.Node{Features: []string{zos.NetworkType, zos.ZMachineType}},
+	}
+	farm := farmInfo{}
+	assert.Equal(t, nodeInfo.fulfils(&Request{}, farm, 1), false, "full node shouldn't fulfil a light request")
+	assert.Equal(t, nodeInfo.fulfils(&Request{NetworkStack: NetworkStackFull}, farm, 1), true, "full node should fulfil a full request")
+	assert.Equal(t, nodeInfo.fulfils(&Request{NetworkStack: NetworkStackAny}, farm, 1), true, "full node should fulfil any network stack")
+	assert.Equal(t, nodeInfo.fulfils(&Request{NetworkStack: NetworkStackAny, PublicIPv6: true}, farm, 1), false, "node without ipv6 shouldn't fulfil an ipv6 request")
+
+	nodeInfo.Node.PublicConfig.Ipv6 = "2a02:1802:5e::1/64"
+	assert.Equal(t, nodeInfo.fulfils(&Request{NetworkStack: NetworkStackAny, PublicIPv6: true}, farm, 1), true, "node with ipv6 should fulfil an ipv6 request")
 }
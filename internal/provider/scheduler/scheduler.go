@@ -4,6 +4,7 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"log"
 	"math/rand"
 	"slices"
 
@@ -27,12 +28,16 @@ type Scheduler struct {
 	twinID          uint64
 	gridProxyClient proxy.Client
 	rmbClient       rmbClient
+	// fallbacks holds the names of requests scheduled on full nodes after no light node matched them
+	fallbacks []string
 }
 
 // nodeInfo related to scheduling
 type nodeInfo struct {
 	FreeCapacity *Capacity
 	Node         proxyTypes.Node
+	// quality is fetched from the node only when a request needs it
+	quality *nodeQuality
 }
 
 type farmInfo struct {
@@ -46,18 +51,47 @@ func (s *Scheduler) consumePublicIPs(farmID uint32, IPs uint32) {
 	farm.freeIPs -= uint64(IPs)
 }
 
-func (node *nodeInfo) fulfils(r *Request, farm farmInfo) bool {
+func (node nodeInfo) rentedBy(twinID uint64) bool {
+	return node.Node.Rented && uint64(node.Node.RentedByTwinID) == twinID
+}
+
+func (node *nodeInfo) fulfils(r *Request, farm farmInfo, twinID uint64) bool {
 	if r.Capacity.MRU > node.FreeCapacity.MRU ||
 		r.Capacity.HRU > node.FreeCapacity.HRU ||
 		r.Capacity.SRU > node.FreeCapacity.SRU ||
 		(r.FarmID != 0 && node.Node.FarmID != int(r.FarmID)) ||
 		(r.PublicConfig && node.Node.PublicConfig.Domain == "") ||
+		(r.PublicIPv6 && node.Node.PublicConfig.Ipv6 == "") ||
+		!hasFeatures(node.Node, r.features()) ||
 		(r.PublicIpsCount > uint32(farm.freeIPs)) ||
 		(r.Dedicated && !node.Node.Dedicated) ||
 		(r.Certified && node.Node.CertificationType != "Certified") ||
-		contains(r.NodeExclude, uint32(node.Node.NodeID)) {
+		(r.RentedByMe == RentedByMeRequired && !node.rentedBy(twinID)) ||
+		contains(r.NodeExclude, uint32(node.Node.NodeID)) ||
+		contains(r.ExcludeFarms, uint32(node.Node.FarmID)) ||
+		(len(r.IncludeNodes) != 0 && !contains(r.IncludeNodes, uint32(node.Node.NodeID))) ||
+		(r.MinUptimePercentage != 0 && uptimePercentage(node.Node, r.UptimeWindow) < r.MinUptimePercentage) ||
+		(r.MinIPv4Bandwidth != 0 && ipv4Bandwidth(node.Node) < float64(r.MinIPv4Bandwidth)) {
 		return false
 	}
+	if r.needsNodeQuality() {
+		if node.quality == nil ||
+			(r.MinZosVersion != "" && !versionAtLeast(node.quality.zosVersion, r.MinZosVersion)) ||
+			(r.MinIPv6Bandwidth != 0 && node.quality.ipv6Bandwidth < float64(r.MinIPv6Bandwidth)) ||
+			(r.Mycelium && !node.supportsMycelium()) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasFeatures reports whether the node supports all the features
+func hasFeatures(node proxyTypes.Node, features []string) bool {
+	for _, feature := range features {
+		if !slices.Contains(node.Features, feature) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -115,19 +149,72 @@ func (n *Scheduler) getNode(ctx context.Context, r *Request) uint32 {
 		nodes = append(nodes, node)
 	}
 	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if r.RentedByMe != "" {
+		// rank nodes rented by the twin first
+		slices.SortStableFunc(nodes, func(a, b uint32) int {
+			rentedA, rentedB := n.nodes[a].rentedBy(n.twinID), n.nodes[b].rentedBy(n.twinID)
+			switch {
+			case rentedA && !rentedB:
+				return -1
+			case !rentedA && rentedB:
+				return 1
+			}
+			return 0
+		})
+	}
 	for _, node := range nodes {
+		if ctx.Err() != nil {
+			// the scheduling timed out, the caller reports the context error
+			return 0
+		}
 		farm, err := n.getFarmInfo(ctx, uint32(n.nodes[node].Node.FarmID))
 		if err != nil {
 			continue
 		}
 		nodeInfo := n.nodes[node]
-		if nodeInfo.fulfils(r, farm) {
+		if r.needsNodeQuality() && nodeInfo.quality == nil {
+			quality, err := n.getNodeQuality(ctx, nodeInfo.Node, r)
+			if err != nil {
+				log.Printf("skipping node %d: %s", node, err.Error())
+				continue
+			}
+			nodeInfo.quality = &quality
+			n.nodes[node] = nodeInfo
+		}
+		if nodeInfo.fulfils(r, farm, n.twinID) {
 			return node
 		}
 	}
 	return 0
 }
 
+// Fallbacks returns the names of the requests scheduled on full nodes after no light node matched them
+func (n *Scheduler) Fallbacks() []string {
+	return n.fallbacks
+}
+
+// NodeDetails returns the grid proxy information of a node, as it was when the scheduler listed it
+func (n *Scheduler) NodeDetails(ctx context.Context, nodeID uint32) (proxyTypes.Node, error) {
+	if node, ok := n.nodes[nodeID]; ok {
+		return node.Node, nil
+	}
+
+	id := uint64(nodeID)
+	nodes, _, err := n.gridProxyClient.Nodes(ctx, proxyTypes.NodeFilter{
+		NodeID: &id,
+	}, proxyTypes.Limit{
+		Size: 1,
+		Page: 1,
+	})
+	if err != nil {
+		return proxyTypes.Node{}, errors.Wrapf(err, "couldn't get node %d from the grid proxy", nodeID)
+	}
+	if len(nodes) == 0 {
+		return proxyTypes.Node{}, fmt.Errorf("node %d not found", nodeID)
+	}
+	return nodes[0], nil
+}
+
 func (n *Scheduler) addNodes(nodes []proxyTypes.Node) {
 	for _, node := range nodes {
 		if _, ok := n.nodes[uint32(node.NodeID)]; !ok {
@@ -142,14 +229,40 @@ func (n *Scheduler) addNodes(nodes []proxyTypes.Node) {
 
 // Schedule makes sure there's at least one node that satisfies the given request
 func (n *Scheduler) Schedule(ctx context.Context, r *Request) (uint32, error) {
-	if r.FarmID != 0 {
+	if err := r.validate(); err != nil {
+		return 0, err
+	}
+	// the farmer bot can't evaluate all requirements, so such requests always go through the grid proxy
+	if r.FarmID != 0 && !r.needsGridProxy() {
 		if n.hasFarmerBot(ctx, r.FarmID) {
+			if r.RentedByMe == "" {
+				return n.farmerBotSchedule(ctx, r)
+			}
+			// nodes rented by the twin are already reserved for it, so they are looked up
+			// through the grid proxy before asking the farmer bot for any other node
+			node, err := n.rentedNodeSchedule(ctx, r)
+			if r.RentedByMe == RentedByMeRequired || !errors.Is(err, NoNodesFoundErr) {
+				return node, err
+			}
 			return n.farmerBotSchedule(ctx, r)
 		}
 	}
+	if r.RentedByMe == RentedByMePreferred {
+		node, err := n.rentedNodeSchedule(ctx, r)
+		if !errors.Is(err, NoNodesFoundErr) {
+			return node, err
+		}
+	}
 	return n.gridProxySchedule(ctx, r)
 }
 
+// rentedNodeSchedule searches only the nodes rented by the twin
+func (n *Scheduler) rentedNodeSchedule(ctx context.Context, r *Request) (uint32, error) {
+	rented := *r
+	rented.RentedByMe = RentedByMeRequired
+	return n.gridProxySchedule(ctx, &rented)
+}
+
 func (n *Scheduler) gridProxySchedule(ctx context.Context, r *Request) (uint32, error) {
 	f := r.constructFilter(n.twinID)
 	l := proxyTypes.Limit{
@@ -160,15 +273,26 @@ func (n *Scheduler) gridProxySchedule(ctx context.Context, r *Request) (uint32,
 
 	node := n.getNode(ctx, r)
 	for node == 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, errors.Wrapf(err, "couldn't find a node for request %s", r.Name)
+		}
 		nodes, _, err := n.gridProxyClient.Nodes(ctx, f, l)
 		if err != nil {
 			return 0, errors.Wrap(err, "couldn't list nodes from the grid proxy")
 		}
-		if len(nodes) == 0 && slices.Contains(f.Features, zos.NetworkType) {
-			return 0, NoNodesFoundErr
-		}
 		if len(nodes) == 0 {
-			f.Features = []string{zos.NetworkType, zos.ZMachineType}
+			if !r.AllowFallback || !slices.Contains(f.Features, zos.NetworkLightType) {
+				return 0, NoNodesFoundErr
+			}
+			log.Printf("no light node found for request %s, falling back to full nodes", r.Name)
+			n.fallbacks = append(n.fallbacks, r.Name)
+			fallback := *r
+			fallback.NetworkStack = NetworkStackFull
+			r = &fallback
+			f.Features = r.features()
+			l.Page, l.Size = 1, 10
+			// full nodes listed by previous requests may fit as well
+			node = n.getNode(ctx, r)
 			continue
 		}
 		n.addNodes(nodes)
@@ -192,9 +316,19 @@ func (s *Scheduler) ProcessRequests(ctx context.Context, reqs []Request, assignm
 		}
 	}
 
+	groupNodes := map[string][]uint32{}
+
 	for _, r := range reqs {
+		group := r.Group
+		if group == "" {
+			group = r.Name
+		}
 		if r.Distinct {
-			r.NodeExclude = append(r.NodeExclude, assignedNodes...)
+			if r.DistinctScope == DistinctScopeRequest {
+				r.NodeExclude = append(r.NodeExclude, groupNodes[group]...)
+			} else {
+				r.NodeExclude = append(r.NodeExclude, assignedNodes...)
+			}
 		}
 		node, err := s.Schedule(ctx, &r)
 		if err != nil {
@@ -204,6 +338,7 @@ func (s *Scheduler) ProcessRequests(ctx context.Context, reqs []Request, assignm
 		if !contains(assignedNodes, node) {
 			assignedNodes = append(assignedNodes, node)
 		}
+		groupNodes[group] = append(groupNodes[group], node)
 	}
 	return nil
 }
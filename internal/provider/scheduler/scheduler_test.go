@@ -7,9 +7,12 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
 	proxyTypes "github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 )
 
+var anyStackFeatures = []string{zos.NetworkType, zos.ZMachineType, zos.NetworkLightType, zos.ZMachineLightType}
+
 type GridProxyClientMock struct {
 	farms []proxyTypes.Farm
 	nodes []proxyTypes.Node
@@ -18,6 +21,7 @@ type GridProxyClientMock struct {
 type RMBClientMock struct {
 	nodeID       uint32
 	hasFarmerBot bool
+	zosVersions  map[uint32]string
 }
 
 func (r *RMBClientMock) CallWithSession(ctx context.Context, twin uint32, session *string, fn string, data interface{}, result interface{}) error {
@@ -34,6 +38,14 @@ func (r *RMBClientMock) CallWithSession(ctx context.Context, twin uint32, sessio
 		output := result.(*uint32)
 		*output = r.nodeID
 		return nil
+	case ZosVersionAction:
+		ver, ok := r.zosVersions[twin]
+		if !ok {
+			return fmt.Errorf("twin %d is not reachable", twin)
+		}
+		output := result.(*zosVersion)
+		output.ZOS = ver
+		return nil
 	default:
 		return fmt.Errorf("fn: %s not supported", fn)
 	}
@@ -44,14 +56,31 @@ func (m *GridProxyClientMock) Ping() error {
 }
 
 func (m *GridProxyClientMock) Nodes(ctx context.Context, filter proxyTypes.NodeFilter, pagination proxyTypes.Limit) (res []proxyTypes.Node, totalCount int, err error) {
+	if filter.NodeID != nil {
+		for _, node := range m.nodes {
+			if uint64(node.NodeID) == *filter.NodeID {
+				return []proxyTypes.Node{node}, 1, nil
+			}
+		}
+		return make([]proxyTypes.Node, 0), 0, nil
+	}
+	nodes := m.nodes
+	if len(filter.Features) != 0 {
+		nodes = make([]proxyTypes.Node, 0)
+		for _, node := range m.nodes {
+			if hasFeatures(node, filter.Features) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
 	start, end := (pagination.Page-1)*pagination.Size, pagination.Page*pagination.Size
-	if int(end) > len(m.nodes) {
-		end = uint64(len(m.nodes))
+	if int(end) > len(nodes) {
+		end = uint64(len(nodes))
 	}
 	if end <= start {
 		return make([]proxyTypes.Node, 0), 0, nil
 	}
-	res = m.nodes[start:end]
+	res = nodes[start:end]
 	return
 }
 
@@ -90,6 +119,10 @@ func (m *GridProxyClientMock) AddFarm(farm proxyTypes.Farm) {
 	m.farms = append(m.farms, farm)
 }
 func (m *GridProxyClientMock) AddNode(id uint32, node proxyTypes.Node) {
+	if len(node.Features) == 0 {
+		// nodes added without features match any network stack
+		node.Features = anyStackFeatures
+	}
 	m.nodes = append(m.nodes, node)
 }
 func (m *GridProxyClientMock) Contracts(ctx context.Context, filter proxyTypes.ContractFilter, pagination proxyTypes.Limit) (res []proxyTypes.Contract, totalCount int, err error) {
@@ -496,3 +529,280 @@ func TestDistinctNodes(t *testing.T) {
 	assert.NotEqual(t, assignment["r1"], assignment["r3"])
 	assert.NotEqual(t, assignment["r2"], assignment["r3"])
 }
+
+func TestSchedulerMinZosVersion(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: true,
+		nodeID:       1,
+		zosVersions: map[uint32]string{
+			11: "v3.10.5",
+			12: "v3.11.1",
+		},
+	}
+	for i := 1; i <= 3; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{
+			NodeID: i,
+			TwinID: i + 10,
+			FarmID: 1,
+		})
+	}
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		FarmID:        1,
+		MinZosVersion: "3.11.0",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(2), "only node 2 runs a recent enough zos version")
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		MinZosVersion: "3.12.0",
+	})
+	assert.Error(t, err, "no node runs zos 3.12.0")
+}
+
+func TestRentedByMe(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: false,
+	}
+	for i := 1; i <= 10; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{
+			NodeID: i,
+			FarmID: 1,
+		})
+	}
+	proxy.AddNode(11, proxyTypes.Node{
+		NodeID:         11,
+		FarmID:         1,
+		Rented:         true,
+		RentedByTwinID: 1,
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		RentedByMe: RentedByMePreferred,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(11), "the node rented by the twin should be preferred")
+
+	node, err = scheduler.Schedule(context.Background(), &Request{
+		RentedByMe: RentedByMeRequired,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(11))
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		RentedByMe:  RentedByMeRequired,
+		NodeExclude: []uint32{11},
+	})
+	assert.Error(t, err, "no other node is rented by the twin")
+
+	node, err = scheduler.Schedule(context.Background(), &Request{
+		RentedByMe:  RentedByMePreferred,
+		NodeExclude: []uint32{11},
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, node, uint32(11))
+}
+
+func TestRentedByMeFarmerBot(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: true,
+		nodeID:       1,
+	}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID: 1,
+		FarmID: 1,
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:         2,
+		FarmID:         1,
+		Rented:         true,
+		RentedByTwinID: 1,
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		FarmID:     1,
+		RentedByMe: RentedByMePreferred,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(2), "the node rented by the twin should be used before asking the farmer bot")
+
+	node, err = scheduler.Schedule(context.Background(), &Request{
+		FarmID:      1,
+		RentedByMe:  RentedByMePreferred,
+		NodeExclude: []uint32{2},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(1), "the farmer bot should be asked when no rented node fits")
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		FarmID:      1,
+		RentedByMe:  RentedByMeRequired,
+		NodeExclude: []uint32{2},
+	})
+	assert.Error(t, err, "the farmer bot shouldn't be asked when a rented node is required")
+}
+
+func TestDistinctScopeRequest(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: false,
+	}
+	for i := 1; i <= 3; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{
+			NodeID: i,
+			FarmID: 1,
+		})
+	}
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	requests := []Request{
+		{
+			Name:          "r-0",
+			Group:         "r",
+			Distinct:      true,
+			DistinctScope: DistinctScopeRequest,
+		},
+		{
+			Name:          "r-1",
+			Group:         "r",
+			Distinct:      true,
+			DistinctScope: DistinctScopeRequest,
+		},
+		{
+			Name:          "r-2",
+			Group:         "r",
+			Distinct:      true,
+			DistinctScope: DistinctScopeRequest,
+		},
+	}
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	assignment := map[string]uint32{
+		"other": 1,
+	}
+	err := scheduler.ProcessRequests(context.Background(), requests, assignment)
+	assert.NoError(t, err)
+	assert.NotEqual(t, assignment["r-0"], assignment["r-1"])
+	assert.NotEqual(t, assignment["r-0"], assignment["r-2"])
+	assert.NotEqual(t, assignment["r-1"], assignment["r-2"])
+	assert.Contains(t, []uint32{assignment["r-0"], assignment["r-1"], assignment["r-2"]}, uint32(1), "nodes of other requests could be reused")
+}
+
+func TestNodeDetails(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: true,
+		nodeID:       2,
+	}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID: 1,
+		FarmID: 1,
+	})
+	proxy.AddNode(2, proxyTypes.Node{
+		NodeID:  2,
+		FarmID:  1,
+		Country: "Belgium",
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		FarmID: 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, node, uint32(2))
+
+	details, err := scheduler.NodeDetails(context.Background(), node)
+	assert.NoError(t, err, "nodes picked by the farmer bot should be looked up")
+	assert.Equal(t, details.Country, "Belgium")
+
+	_, err = scheduler.NodeDetails(context.Background(), 3)
+	assert.Error(t, err)
+}
+
+func TestNetworkStackFallback(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: false,
+	}
+	proxy.AddNode(1, proxyTypes.Node{
+		NodeID:   1,
+		FarmID:   1,
+		Features: []string{zos.NetworkType, zos.ZMachineType},
+	})
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		Name:         "full",
+		NetworkStack: NetworkStackFull,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), node)
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		Name: "r1",
+	})
+	assert.Error(t, err, "only full nodes are available and fallback is not allowed, even the one listed for the previous request")
+	assert.Empty(t, scheduler.Fallbacks())
+
+	node, err = scheduler.Schedule(context.Background(), &Request{
+		Name:          "r2",
+		AllowFallback: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), node)
+	assert.Equal(t, []string{"r2"}, scheduler.Fallbacks())
+
+	_, err = scheduler.Schedule(context.Background(), &Request{
+		Name:         "r3",
+		NetworkStack: NetworkStackLight,
+		Yggdrasil:    true,
+	})
+	assert.Error(t, err, "yggdrasil isn't supported on light nodes")
+}
+
+func TestSchedulerMycelium(t *testing.T) {
+	proxy := &GridProxyClientMock{}
+	rmbClient := &RMBClientMock{
+		hasFarmerBot: false,
+		zosVersions: map[uint32]string{
+			11: "v3.9.2",
+			12: "v3.10.1",
+		},
+	}
+	for i := 1; i <= 2; i++ {
+		proxy.AddNode(uint32(i), proxyTypes.Node{
+			NodeID:   i,
+			TwinID:   i + 10,
+			FarmID:   1,
+			Features: []string{zos.NetworkType, zos.ZMachineType},
+		})
+	}
+	proxy.AddFarm(proxyTypes.Farm{
+		FarmID: 1,
+	})
+	scheduler := NewScheduler(proxy, 1, rmbClient)
+	node, err := scheduler.Schedule(context.Background(), &Request{
+		NetworkStack: NetworkStackFull,
+		Mycelium:     true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), node, "only node 2 runs a zos version supporting mycelium")
+}
@@ -0,0 +1,127 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// secureEnvHashPrefix marks the secure env vars values kept in the state, which only holds their hashes
+const secureEnvHashPrefix = "sha256:"
+
+// secureEnvHash is the form a secure env var value is stored in the state
+func secureEnvHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return secureEnvHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// isSecureEnvHash reports whether a secure env var value is already hashed
+func isSecureEnvHash(value string) bool {
+	hash, ok := strings.CutPrefix(value, secureEnvHashPrefix)
+	if !ok || len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// suppressSecureEnvDiff hides the diff of the secure env vars whose configured value matches the hash in the state
+func suppressSecureEnvDiff(k, old, new string, d *schema.ResourceData) bool {
+	if strings.HasSuffix(k, ".%") {
+		return false
+	}
+	return old != "" && old == secureEnvHash(new)
+}
+
+// hashSecureEnv replaces the secure env vars values of a configured vm with their hashes before it's stored in the state
+func hashSecureEnv(vmMap map[string]interface{}) {
+	secureEnv, ok := vmMap["secure_env_vars"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	hashed := make(map[string]interface{}, len(secureEnv))
+	for key, value := range secureEnv {
+		if v := value.(string); isSecureEnvHash(v) {
+			hashed[key] = v
+		} else {
+			hashed[key] = secureEnvHash(v)
+		}
+	}
+	vmMap["secure_env_vars"] = hashed
+}
+
+// configuredSecureEnv returns the secure env vars of the configured vms by vm name. The planned values of the secure
+// env vars that didn't change are the hashes in the state, so their values are read from the configuration. It falls
+// back to the planned values when there is no configuration
+func configuredSecureEnv(d *schema.ResourceData) map[string]map[string]string {
+	secureEnv := make(map[string]map[string]string)
+	config := d.GetRawConfig()
+	if config.IsNull() || !config.IsKnown() {
+		for _, vm := range d.Get("vms").([]interface{}) {
+			vmMap := vm.(map[string]interface{})
+			env := make(map[string]string)
+			for key, value := range vmMap["secure_env_vars"].(map[string]interface{}) {
+				env[key] = value.(string)
+			}
+			secureEnv[vmMap["name"].(string)] = env
+		}
+		return secureEnv
+	}
+
+	vms := config.GetAttr("vms")
+	if vms.IsNull() || !vms.IsKnown() {
+		return secureEnv
+	}
+	for it := vms.ElementIterator(); it.Next(); {
+		_, vm := it.Element()
+		name, env := vm.GetAttr("name"), vm.GetAttr("secure_env_vars")
+		if name.IsNull() || !name.IsKnown() || env.IsNull() || !env.IsKnown() {
+			continue
+		}
+		values := make(map[string]string)
+		for key, value := range env.AsValueMap() {
+			if !value.IsNull() && value.IsKnown() {
+				values[key] = value.AsString()
+			}
+		}
+		secureEnv[name.AsString()] = values
+	}
+	return secureEnv
+}
+
+// setVMSecureEnv passes the secure env vars of a vm to it with its env vars, zos receives both the same way
+func setVMSecureEnv(vmMap map[string]interface{}, secureEnv map[string]string) error {
+	envVars, _ := vmMap["env_vars"].(map[string]interface{})
+	if envVars == nil {
+		envVars = make(map[string]interface{})
+	}
+	for key, value := range secureEnv {
+		if _, ok := envVars[key]; ok {
+			return fmt.Errorf("vm %s sets %s in both env_vars and secure_env_vars", vmMap["name"], key)
+		}
+		envVars[key] = value
+	}
+	vmMap["env_vars"] = envVars
+	delete(vmMap, "secure_env_vars")
+	return nil
+}
+
+// moveSecureEnv moves the env vars of a deployed vm that the configured vm, if any, sets as secure env vars back to
+// secure_env_vars, hashed, so a value changed on the node shows up as a diff
+func moveSecureEnv(deployed map[string]interface{}, configured map[string]interface{}) {
+	envVars, _ := deployed["env_vars"].(map[string]interface{})
+	configuredEnv, _ := configured["secure_env_vars"].(map[string]interface{})
+
+	secureEnv := make(map[string]interface{})
+	for key := range configuredEnv {
+		if value, ok := envVars[key].(string); ok {
+			secureEnv[key] = secureEnvHash(value)
+			delete(envVars, key)
+		}
+	}
+	deployed["secure_env_vars"] = secureEnv
+}
@@ -0,0 +1,80 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureEnvHash(t *testing.T) {
+	hash := secureEnvHash("secret")
+	assert.Equal(t, "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", hash)
+	assert.True(t, isSecureEnvHash(hash))
+	assert.False(t, isSecureEnvHash("secret"))
+	assert.False(t, isSecureEnvHash("sha256:secret"))
+
+	vmMap := map[string]interface{}{"secure_env_vars": map[string]interface{}{"NEW": "secret", "KEPT": hash}}
+	hashSecureEnv(vmMap)
+	assert.Equal(t, map[string]interface{}{"NEW": hash, "KEPT": hash}, vmMap["secure_env_vars"])
+}
+
+func TestSetVMSecureEnv(t *testing.T) {
+	vmMap := map[string]interface{}{
+		"name":            "vm",
+		"env_vars":        map[string]interface{}{"KEY": "value"},
+		"secure_env_vars": map[string]interface{}{"TOKEN": secureEnvHash("secret")},
+	}
+	assert.NoError(t, setVMSecureEnv(vmMap, map[string]string{"TOKEN": "secret"}))
+	assert.Equal(t, map[string]interface{}{
+		"name":     "vm",
+		"env_vars": map[string]interface{}{"KEY": "value", "TOKEN": "secret"},
+	}, vmMap)
+
+	vmMap = map[string]interface{}{"name": "vm", "env_vars": map[string]interface{}{"TOKEN": "value"}}
+	assert.Error(t, setVMSecureEnv(vmMap, map[string]string{"TOKEN": "secret"}), "a key can't be set twice")
+}
+
+func TestMoveSecureEnv(t *testing.T) {
+	deployed := map[string]interface{}{
+		"env_vars": map[string]interface{}{"KEY": "value", "TOKEN": "changed"},
+	}
+	moveSecureEnv(deployed, map[string]interface{}{
+		"secure_env_vars": map[string]interface{}{"TOKEN": secureEnvHash("secret"), "REMOVED": secureEnvHash("secret")},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"env_vars":        map[string]interface{}{"KEY": "value"},
+		"secure_env_vars": map[string]interface{}{"TOKEN": secureEnvHash("changed")},
+	}, deployed, "the changed and removed values show up as a diff")
+}
+
+func TestSecureEnvDiff(t *testing.T) {
+	vm := func(secret string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":            "vm",
+			"flist":           "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+			"secure_env_vars": map[string]interface{}{"TOKEN": secret},
+		}
+	}
+	old := schema.TestResourceDataRaw(t, resourceDeployment().Schema, map[string]interface{}{
+		"node": 1,
+		"vms":  []interface{}{vm(secureEnvHash("secret"))},
+	})
+	old.SetId("1")
+
+	sm := schema.InternalMap(resourceDeployment().Schema)
+	diff := func(secret string) *terraform.InstanceDiff {
+		diff, err := sm.Diff(context.Background(), old.State(), terraform.NewResourceConfigRaw(map[string]interface{}{
+			"node": 1,
+			"vms":  []interface{}{vm(secret)},
+		}), nil, nil, true)
+		assert.NoError(t, err)
+		return diff
+	}
+
+	assert.NotContains(t, diff("secret").Attributes, "vms.0.secure_env_vars.TOKEN")
+	assert.Contains(t, diff("rotated").Attributes, "vms.0.secure_env_vars.TOKEN")
+}
@@ -0,0 +1,40 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// cleanupTimeout bounds the node calls that record or revert the work done before an operation timed out
+const cleanupTimeout = 2 * time.Minute
+
+// resourceFunc is the signature shared by the create, read, update and delete functions of the resources
+type resourceFunc = func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics
+
+// withTimeout explains the errors of an operation that ran out of its timeout. The sdk runs each operation with a
+// context that expires after the resource timeout, so the deployers stop their node calls as soon as it fires
+func withTimeout(timeoutKey string, f resourceFunc) resourceFunc {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		diags := f(ctx, d, meta)
+		if diags.HasError() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("%s timed out after %s", timeoutKey, d.Timeout(timeoutKey)),
+				Detail:   fmt.Sprintf("The node calls were canceled, set a longer %s in the timeouts block of the resource to give them more time.", timeoutKey),
+			})
+		}
+		return diags
+	}
+}
+
+// cleanupContext returns a context for the calls that have to run even if the operation context expired,
+// like canceling the contracts of a failed migration
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
@@ -0,0 +1,43 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTimeout(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceGatewayNameProxy().Schema, map[string]interface{}{})
+	failing := func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		<-ctx.Done()
+		return diag.FromErr(ctx.Err())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	diags := withTimeout(schema.TimeoutCreate, failing)(ctx, d, nil)
+	assert.Len(t, diags, 2)
+	assert.Contains(t, diags[1].Summary, "create timed out after")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	diags = withTimeout(schema.TimeoutCreate, failing)(ctx, d, nil)
+	assert.Len(t, diags, 1, "only timeouts are explained")
+}
+
+func TestResourcesTimeouts(t *testing.T) {
+	provider, _ := New("dev", nil)
+	for name, r := range provider().ResourcesMap {
+		assert.NotNil(t, r.Timeouts, name)
+		for _, timeout := range []**time.Duration{&r.Timeouts.Create, &r.Timeouts.Read, &r.Timeouts.Delete} {
+			assert.NotNil(t, *timeout, name)
+		}
+		// resources whose attributes all force a new resource have no update
+		assert.Equal(t, r.UpdateContext != nil, r.Timeouts.Update != nil, name)
+	}
+}
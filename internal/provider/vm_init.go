@@ -0,0 +1,126 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKeyEnv is read by zos to add the keys to the cloud-init users of full vms, and by the official flists to fill authorized_keys
+const sshKeyEnv = "SSH_KEY"
+
+func validateSSHKey(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if strings.ContainsAny(strings.TrimSpace(v), "\r\n") {
+		return nil, []error{fmt.Errorf("expected %s to hold a single ssh public key", k)}
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(v)); err != nil {
+		return nil, []error{fmt.Errorf("expected %s to be a valid ssh public key, got %s: %w", k, v, err)}
+	}
+	return nil, nil
+}
+
+// joinSSHKeys joins the non empty keys the way the SSH_KEY env var holds them, one key per line
+func joinSSHKeys(keys ...string) string {
+	nonEmpty := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			nonEmpty = append(nonEmpty, key)
+		}
+	}
+	return strings.Join(nonEmpty, "\n")
+}
+
+// splitSSHKeys splits the SSH_KEY env var into its keys
+func splitSSHKeys(keys string) []string {
+	split := make([]string, 0)
+	for _, key := range strings.Split(keys, "\n") {
+		if key = strings.TrimSpace(key); key != "" {
+			split = append(split, key)
+		}
+	}
+	return split
+}
+
+// setVMInitEnv passes the vm ssh keys to the vm as an env var, they can't be set through env_vars as well
+func setVMInitEnv(vmMap map[string]interface{}) error {
+	envVars, _ := vmMap["env_vars"].(map[string]interface{})
+	if envVars == nil {
+		envVars = make(map[string]interface{})
+	}
+
+	sshKeys := make([]string, 0)
+	for _, key := range vmMap["ssh_keys"].([]interface{}) {
+		sshKeys = append(sshKeys, key.(string))
+	}
+	if len(sshKeys) != 0 {
+		if _, ok := envVars[sshKeyEnv]; ok {
+			return fmt.Errorf("vm %s sets both ssh_keys and the %s env var", vmMap["name"], sshKeyEnv)
+		}
+		envVars[sshKeyEnv] = joinSSHKeys(sshKeys...)
+	}
+
+	vmMap["env_vars"] = envVars
+	return nil
+}
+
+// moveVMInitEnv moves the ssh keys env var of a deployed vm back to its attribute, unless the configured vm, if any,
+// sets it through env_vars
+func moveVMInitEnv(deployed map[string]interface{}, configured map[string]interface{}) {
+	envVars, _ := deployed["env_vars"].(map[string]interface{})
+	configuredEnv, _ := configured["env_vars"].(map[string]interface{})
+	configuredKeys, _ := configured["ssh_keys"].([]interface{})
+
+	sshKeys := make([]interface{}, 0)
+	if keys, ok := envVars[sshKeyEnv].(string); ok {
+		if _, set := configuredEnv[sshKeyEnv]; !set {
+			sshKeys = matchSSHKeys(keys, configuredKeys)
+			delete(envVars, sshKeyEnv)
+		}
+	}
+	deployed["ssh_keys"] = sshKeys
+}
+
+// matchSSHKeys returns the deployed keys, keeping the configured form of the keys that only differ in surrounding spaces
+func matchSSHKeys(deployed string, configured []interface{}) []interface{} {
+	keys := make([]interface{}, 0)
+	for _, key := range splitSSHKeys(deployed) {
+		match := slices.IndexFunc(configured, func(c interface{}) bool {
+			return strings.TrimSpace(c.(string)) == key
+		})
+		if match != -1 {
+			keys = append(keys, configured[match])
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// splitClusterSSHKeys splits the SSH_KEY env var of a cluster into its configured ssh_key and ssh_keys
+func splitClusterSSHKeys(deployed string, configuredKey string, configuredKeys []interface{}) (string, []interface{}) {
+	if len(configuredKeys) == 0 {
+		return deployed, []interface{}{}
+	}
+
+	remaining := splitSSHKeys(deployed)
+	sshKeys := make([]interface{}, 0)
+	for _, key := range configuredKeys {
+		if i := slices.Index(remaining, strings.TrimSpace(key.(string))); i != -1 {
+			sshKeys = append(sshKeys, key)
+			remaining = slices.Delete(remaining, i, i+1)
+		}
+	}
+
+	sshKey := joinSSHKeys(remaining...)
+	if sshKey == strings.TrimSpace(configuredKey) {
+		sshKey = configuredKey
+	}
+	return sshKey, sshKeys
+}
@@ -0,0 +1,77 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSSHKey1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOWbyPiCsq5camouvd5mbyqziBZ/eK4PU4XRGqfYogkw user1"
+	testSSHKey2 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOLMO2wvZeZzSGhd/pUm1Qox47IbWECy28DXs6/Nyjnu user2"
+)
+
+func TestValidateSSHKey(t *testing.T) {
+	_, errs := validateSSHKey(testSSHKey1, "ssh_keys.0")
+	assert.Empty(t, errs)
+
+	_, errs = validateSSHKey(testSSHKey1+"\n", "ssh_keys.0")
+	assert.Empty(t, errs, "a trailing newline, as read from a file, is allowed")
+
+	_, errs = validateSSHKey(testSSHKey1+"\n"+testSSHKey2, "ssh_keys.0")
+	assert.NotEmpty(t, errs, "each item holds a single key")
+
+	_, errs = validateSSHKey("ssh-ed25519 invalid", "ssh_keys.0")
+	assert.NotEmpty(t, errs)
+}
+
+func TestSetVMInitEnv(t *testing.T) {
+	vmMap := map[string]interface{}{
+		"name":     "vm",
+		"env_vars": map[string]interface{}{"KEY": "value"},
+		"ssh_keys": []interface{}{testSSHKey1 + "\n", testSSHKey2},
+	}
+	assert.NoError(t, setVMInitEnv(vmMap))
+	assert.Equal(t, map[string]interface{}{
+		"KEY":     "value",
+		sshKeyEnv: testSSHKey1 + "\n" + testSSHKey2,
+	}, vmMap["env_vars"])
+
+	vmMap = map[string]interface{}{
+		"name":     "vm",
+		"env_vars": map[string]interface{}{sshKeyEnv: testSSHKey1},
+		"ssh_keys": []interface{}{testSSHKey2},
+	}
+	assert.Error(t, setVMInitEnv(vmMap), "ssh keys can't be set twice")
+}
+
+func TestMoveVMInitEnv(t *testing.T) {
+	deployed := map[string]interface{}{
+		"env_vars": map[string]interface{}{"KEY": "value", sshKeyEnv: testSSHKey1 + "\n" + testSSHKey2},
+	}
+	moveVMInitEnv(deployed, map[string]interface{}{"ssh_keys": []interface{}{testSSHKey1 + "\n"}})
+	assert.Equal(t, map[string]interface{}{
+		"env_vars": map[string]interface{}{"KEY": "value"},
+		"ssh_keys": []interface{}{testSSHKey1 + "\n", testSSHKey2},
+	}, deployed)
+
+	deployed = map[string]interface{}{
+		"env_vars": map[string]interface{}{sshKeyEnv: testSSHKey1},
+	}
+	moveVMInitEnv(deployed, map[string]interface{}{"env_vars": map[string]interface{}{sshKeyEnv: testSSHKey1}})
+	assert.Equal(t, map[string]interface{}{
+		"env_vars": map[string]interface{}{sshKeyEnv: testSSHKey1},
+		"ssh_keys": []interface{}{},
+	}, deployed, "keys configured through env_vars stay there")
+}
+
+func TestSplitClusterSSHKeys(t *testing.T) {
+	sshKey, sshKeys := splitClusterSSHKeys(testSSHKey1+"\n", testSSHKey1+"\n", nil)
+	assert.Equal(t, testSSHKey1+"\n", sshKey)
+	assert.Empty(t, sshKeys)
+
+	sshKey, sshKeys = splitClusterSSHKeys(testSSHKey1+"\n"+testSSHKey2, testSSHKey1+"\n", []interface{}{testSSHKey2})
+	assert.Equal(t, testSSHKey1+"\n", sshKey)
+	assert.Equal(t, []interface{}{testSSHKey2}, sshKeys)
+}
@@ -0,0 +1,207 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+const (
+	vmNetworkPrivate   = "private"
+	vmNetworkPlanetary = "planetary"
+	vmNetworkMycelium  = "mycelium"
+	vmNetworkPublic    = "public"
+	vmNetworkPublic6   = "public6"
+
+	readinessCheckInterval = 5 * time.Second
+	readinessDialTimeout   = 10 * time.Second
+)
+
+// vmNetworks is the order the vm addresses are tried in if the readiness check doesn't specify a network
+var vmNetworks = []string{vmNetworkPublic, vmNetworkPublic6, vmNetworkMycelium, vmNetworkPlanetary, vmNetworkPrivate}
+
+// vmReadinessCheck is a check the vm has to pass after it's deployed
+type vmReadinessCheck struct {
+	network    string
+	port       int
+	httpPath   string
+	httpStatus int
+	timeout    time.Duration
+}
+
+// parseVMReadinessChecks reads the `wait_for` block of each vm of the deployment
+func parseVMReadinessChecks(d *schema.ResourceData) (map[string]vmReadinessCheck, error) {
+	checks := make(map[string]vmReadinessCheck)
+	for _, vm := range d.Get("vms").([]interface{}) {
+		vmMap := vm.(map[string]interface{})
+		waitFor := vmMap["wait_for"].([]interface{})
+		if len(waitFor) == 0 || waitFor[0] == nil {
+			continue
+		}
+
+		checkMap := waitFor[0].(map[string]interface{})
+		timeout, err := time.ParseDuration(checkMap["timeout"].(string))
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse wait_for timeout of vm %s", vmMap["name"])
+		}
+
+		checks[vmMap["name"].(string)] = vmReadinessCheck{
+			network:    checkMap["network"].(string),
+			port:       checkMap["port"].(int),
+			httpPath:   checkMap["http_path"].(string),
+			httpStatus: checkMap["http_status"].(int),
+			timeout:    timeout,
+		}
+	}
+	return checks, nil
+}
+
+// vmAddresses returns the ips of the vm deployed on one of the nodes per network
+func vmAddresses(dls []*workloads.Deployment, name string) map[string]string {
+	addresses := make(map[string]string)
+	for _, dl := range dls {
+		dlAddresses(dl, name, addresses)
+	}
+
+	for network, ip := range addresses {
+		if ip == "" {
+			delete(addresses, network)
+		}
+	}
+	return addresses
+}
+
+// dlAddresses adds the ips of the vm to the addresses if it is deployed in the deployment
+func dlAddresses(dl *workloads.Deployment, name string, addresses map[string]string) {
+	for _, vm := range dl.Vms {
+		if vm.Name != name {
+			continue
+		}
+		addresses[vmNetworkPrivate] = vm.IP
+		addresses[vmNetworkPlanetary] = vm.PlanetaryIP
+		addresses[vmNetworkMycelium] = vm.MyceliumIP
+		// public ips are reported in CIDR notation
+		addresses[vmNetworkPublic], _, _ = strings.Cut(vm.ComputedIP, "/")
+		addresses[vmNetworkPublic6], _, _ = strings.Cut(vm.ComputedIP6, "/")
+	}
+	for _, vm := range dl.VmsLight {
+		if vm.Name != name {
+			continue
+		}
+		addresses[vmNetworkPrivate] = vm.IP
+		addresses[vmNetworkMycelium] = vm.MyceliumIP
+	}
+}
+
+// waitForVMs runs the readiness checks of the deployed vms, and returns the address each vm was reached on
+func waitForVMs(ctx context.Context, dls []*workloads.Deployment, checks map[string]vmReadinessCheck) (map[string]string, error) {
+	start := time.Now()
+	reached := make(map[string]string)
+
+	var errs error
+	for name, check := range checks {
+		addresses := vmAddresses(dls, name)
+		if len(addresses) == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("vm %s is not deployed", name))
+			continue
+		}
+
+		candidates := make([]string, 0)
+		for _, network := range vmNetworks {
+			ip, ok := addresses[network]
+			if ok && (check.network == "" || check.network == network) {
+				candidates = append(candidates, net.JoinHostPort(ip, fmt.Sprint(check.port)))
+			}
+		}
+		if len(candidates) == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("vm %s has no %s address", name, check.network))
+			continue
+		}
+
+		// all vms boot at the same time, so their timeouts count from the same start
+		checkCtx, cancel := context.WithDeadline(ctx, start.Add(check.timeout))
+		address, err := waitForAddress(checkCtx, candidates, check)
+		cancel()
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "vm %s is not ready", name))
+			continue
+		}
+		reached[name] = address
+	}
+
+	return reached, errs
+}
+
+// waitForAddress polls the candidate addresses until one of them passes the check
+func waitForAddress(ctx context.Context, candidates []string, check vmReadinessCheck) (string, error) {
+	var lastErr error
+	for {
+		for _, address := range candidates {
+			lastErr = checkAddress(ctx, address, check)
+			if lastErr == nil {
+				return address, nil
+			}
+			log.Printf("readiness check on %s failed: %v", address, lastErr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(lastErr, "timed out waiting for %s", strings.Join(candidates, ", "))
+		case <-time.After(readinessCheckInterval):
+		}
+	}
+}
+
+func checkAddress(ctx context.Context, address string, check vmReadinessCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessDialTimeout)
+	defer cancel()
+
+	if check.httpPath == "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	path := check.httpPath
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", address, path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != check.httpStatus {
+		return fmt.Errorf("expected status %d, got %d", check.httpStatus, resp.StatusCode)
+	}
+	return nil
+}
+
+// setVMsReadyAddress stores the address each vm passed its readiness check on
+func setVMsReadyAddress(d *schema.ResourceData, reached map[string]string) error {
+	vms := d.Get("vms").([]interface{})
+	for _, vm := range vms {
+		vmMap := vm.(map[string]interface{})
+		vmMap["ready_address"] = reached[vmMap["name"].(string)]
+	}
+	return d.Set("vms", vms)
+}
@@ -0,0 +1,74 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func TestVMAddresses(t *testing.T) {
+	dl := &workloads.Deployment{
+		Vms: []workloads.VM{{
+			Name:       "vm1",
+			IP:         "10.1.3.2",
+			MyceliumIP: "5c4:c176:bf04:b2ab:ff0f:1b31:7f46:3c45",
+			ComputedIP: "185.206.122.33/24",
+		}},
+		VmsLight: []workloads.VMLight{{Name: "vm2", IP: "10.1.3.3"}},
+	}
+
+	assert.Equal(t, map[string]string{
+		vmNetworkPrivate:  "10.1.3.2",
+		vmNetworkMycelium: "5c4:c176:bf04:b2ab:ff0f:1b31:7f46:3c45",
+		vmNetworkPublic:   "185.206.122.33",
+	}, vmAddresses([]*workloads.Deployment{dl}, "vm1"))
+	assert.Equal(t, map[string]string{vmNetworkPrivate: "10.1.3.3"}, vmAddresses([]*workloads.Deployment{dl}, "vm2"))
+	assert.Empty(t, vmAddresses([]*workloads.Deployment{dl}, "vm3"))
+}
+
+func TestWaitForVMs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	assert.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	assert.NoError(t, err)
+
+	dl := &workloads.Deployment{
+		VmsLight: []workloads.VMLight{{Name: "vm1", IP: host}},
+	}
+
+	reached, err := waitForVMs(context.Background(), []*workloads.Deployment{dl}, map[string]vmReadinessCheck{
+		"vm1": {port: port, timeout: time.Minute},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"vm1": server.Listener.Addr().String()}, reached)
+
+	_, err = waitForVMs(context.Background(), []*workloads.Deployment{dl}, map[string]vmReadinessCheck{
+		"vm1": {network: vmNetworkPrivate, port: port, httpPath: "healthz", httpStatus: http.StatusOK, timeout: time.Minute},
+	})
+	assert.NoError(t, err)
+
+	_, err = waitForVMs(context.Background(), []*workloads.Deployment{dl}, map[string]vmReadinessCheck{
+		"vm1": {port: port, httpPath: "/missing", httpStatus: http.StatusOK, timeout: time.Millisecond},
+	})
+	assert.Error(t, err, "path doesn't exist")
+
+	_, err = waitForVMs(context.Background(), []*workloads.Deployment{dl}, map[string]vmReadinessCheck{
+		"vm1": {network: vmNetworkMycelium, port: port, timeout: time.Minute},
+	})
+	assert.Error(t, err, "vm has no mycelium ip")
+}
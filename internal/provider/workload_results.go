@@ -0,0 +1,213 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/zos"
+)
+
+// workloadResult is the result the node reported for a deployment workload
+type workloadResult struct {
+	state   string
+	message string
+	version int
+}
+
+func (r workloadResult) isOkay() bool {
+	return zos.ResultState(r.state).IsOkay()
+}
+
+// deployDeployment deploys the deployment like the deployment deployer does, except that a failed deployment is not
+// reverted, so the workloads that were deployed and the errors of the failed ones can be recorded
+func deployDeployment(ctx context.Context, tfPluginClient *deployer.TFPluginClient, dl *workloads.Deployment) error {
+	if err := tfPluginClient.DeploymentDeployer.Validate(ctx, []*workloads.Deployment{dl}); err != nil {
+		return fmt.Errorf("invalid deployment: %w", err)
+	}
+
+	dlsPerNodes, err := tfPluginClient.DeploymentDeployer.GenerateVersionlessDeployments(ctx, []*workloads.Deployment{dl})
+	if err != nil {
+		return errors.Wrap(err, "could not generate deployments data")
+	}
+	if len(dlsPerNodes[dl.NodeID]) == 0 {
+		return fmt.Errorf("failed to generate the grid deployment")
+	}
+
+	nodeDeployer := deployer.NewDeployer(*tfPluginClient, false)
+	dl.NodeDeploymentID, err = nodeDeployer.Deploy(
+		ctx, dl.NodeDeploymentID,
+		map[uint32]zos.Deployment{dl.NodeID: dlsPerNodes[dl.NodeID][0]},
+		map[uint32]*uint64{dl.NodeID: dl.SolutionProvider},
+	)
+
+	// the contract is tracked even if the deployment failed
+	if contractID, ok := dl.NodeDeploymentID[dl.NodeID]; ok && contractID != 0 {
+		dl.ContractID = contractID
+		tfPluginClient.State.StoreContractIDs(dl.NodeID, dl.ContractID)
+	}
+	return err
+}
+
+// deployDeployments deploys the deployment of each node, a failure on a node doesn't stop the others from being deployed
+func deployDeployments(ctx context.Context, tfPluginClient *deployer.TFPluginClient, dls []*workloads.Deployment) (errs error) {
+	for _, dl := range dls {
+		if err := deployDeployment(ctx, tfPluginClient, dl); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "couldn't deploy on node %d", dl.NodeID))
+		}
+	}
+	return
+}
+
+// hasContracts reports whether any of the deployments got a contract
+func hasContracts(dls []*workloads.Deployment) bool {
+	return slices.ContainsFunc(dls, func(dl *workloads.Deployment) bool {
+		return dl.ContractID != 0
+	})
+}
+
+// loadWorkloadResults fetches the results of the deployment workloads from the node
+func loadWorkloadResults(ctx context.Context, tfPluginClient *deployer.TFPluginClient, dl *workloads.Deployment) (map[string]workloadResult, error) {
+	results := make(map[string]workloadResult)
+	if dl.ContractID == 0 {
+		return results, nil
+	}
+
+	nodeClient, err := tfPluginClient.NcPool.GetNodeClient(tfPluginClient.SubstrateConn, dl.NodeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get node client '%d'", dl.NodeID)
+	}
+
+	zosDeployment, err := nodeClient.DeploymentGet(ctx, dl.ContractID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't get deployment %d from node %d", dl.ContractID, dl.NodeID)
+	}
+
+	for _, w := range zosDeployment.Workloads {
+		results[w.Name] = workloadResult{
+			state:   string(w.Result.State),
+			message: w.Result.Error,
+			version: int(w.Version),
+		}
+	}
+	return results, nil
+}
+
+// configuredWorkloads returns the workloads of each deployment block
+func configuredWorkloads(d *schema.ResourceData) map[string][]interface{} {
+	configured := make(map[string][]interface{})
+	for _, block := range deploymentWorkloadBlocks {
+		configured[block] = d.Get(block).([]interface{})
+	}
+	return configured
+}
+
+// mergeWorkloadResults keeps the configured workloads that failed on the node next to the synced ones, with their secure
+// env vars hashed, in the configured order, and sets the state, message and version reported by the node on each of them
+func mergeWorkloadResults(configured, synced []interface{}, results map[string]workloadResult) []interface{} {
+	syncedWorkloads := make(map[string]map[string]interface{})
+	for _, w := range synced {
+		wMap := w.(map[string]interface{})
+		syncedWorkloads[wMap["name"].(string)] = wMap
+	}
+
+	merged := make([]interface{}, 0, len(synced))
+	added := make(map[string]bool)
+	for _, w := range configured {
+		wMap := w.(map[string]interface{})
+		name := wMap["name"].(string)
+		if syncedWorkload, ok := syncedWorkloads[name]; ok {
+			merged = append(merged, syncedWorkload)
+			added[name] = true
+			continue
+		}
+		if result, ok := results[name]; ok && !result.isOkay() {
+			hashSecureEnv(wMap)
+			merged = append(merged, wMap)
+			added[name] = true
+		}
+	}
+	for _, w := range synced {
+		wMap := w.(map[string]interface{})
+		if !added[wMap["name"].(string)] {
+			merged = append(merged, wMap)
+		}
+	}
+
+	for _, w := range merged {
+		wMap := w.(map[string]interface{})
+		result := results[wMap["name"].(string)]
+		wMap["state"] = result.state
+		wMap["message"] = result.message
+		wMap["version"] = result.version
+	}
+	return merged
+}
+
+// storeWorkloadResults records the node results of the synced workloads and of the configured workloads that failed
+func storeWorkloadResults(d *schema.ResourceData, configured map[string][]interface{}, results map[string]workloadResult) (errors error) {
+	for _, block := range deploymentWorkloadBlocks {
+		merged := mergeWorkloadResults(configured[block], d.Get(block).([]interface{}), results)
+		if err := d.Set(block, merged); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set %s with error: %w", block, err))
+		}
+	}
+	return
+}
+
+// workloadResultsDiags warns about the workloads that are not in an ok state
+func workloadResultsDiags(results map[string]workloadResult) diag.Diagnostics {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var diags diag.Diagnostics
+	for _, name := range names {
+		result := results[name]
+		if result.isOkay() {
+			continue
+		}
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("workload %s is in %s state", name, result.state),
+			Detail:   result.message,
+		})
+	}
+	return diags
+}
+
+// recordWorkloadResults fetches the workload results of the deployments from their nodes and stores them
+func recordWorkloadResults(ctx context.Context, d *schema.ResourceData, tfPluginClient *deployer.TFPluginClient, dls []*workloads.Deployment, configured map[string][]interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	results := make(map[string]workloadResult)
+	for _, dl := range dls {
+		dlResults, err := loadWorkloadResults(ctx, tfPluginClient, dl)
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("couldn't read workloads results of node %d", dl.NodeID),
+				Detail:   err.Error(),
+			})
+			continue
+		}
+		maps.Copy(results, dlResults)
+	}
+	if len(diags) != 0 {
+		return diags
+	}
+
+	if err := storeWorkloadResults(d, configured, results); err != nil {
+		return diag.Errorf("couldn't set workloads results to the resource with error: %v", err)
+	}
+	return workloadResultsDiags(results)
+}
@@ -0,0 +1,45 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeWorkloadResults(t *testing.T) {
+	results := map[string]workloadResult{
+		"disk1": {state: "ok", version: 1},
+		"disk2": {state: "error", message: "not enough storage", version: 0},
+		"disk4": {state: "paused", version: 2},
+	}
+	configured := []interface{}{
+		map[string]interface{}{"name": "disk1", "size": 10},
+		map[string]interface{}{"name": "disk2", "size": 2048},
+		map[string]interface{}{"name": "disk3", "size": 10},
+	}
+	synced := []interface{}{
+		map[string]interface{}{"name": "disk4", "size": 5},
+		map[string]interface{}{"name": "disk1", "size": 10},
+	}
+
+	merged := mergeWorkloadResults(configured, synced, results)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "disk1", "size": 10, "state": "ok", "message": "", "version": 1},
+		map[string]interface{}{"name": "disk2", "size": 2048, "state": "error", "message": "not enough storage", "version": 0},
+		map[string]interface{}{"name": "disk4", "size": 5, "state": "paused", "message": "", "version": 2},
+	}, merged, "disk3 isn't on the node so it's dropped, and the failed disk2 keeps its configuration")
+}
+
+func TestWorkloadResultsDiags(t *testing.T) {
+	diags := workloadResultsDiags(map[string]workloadResult{
+		"vm":   {state: "error", message: "failed to boot"},
+		"disk": {state: "ok"},
+		"zdb":  {state: "deleted", message: "contract canceled"},
+	})
+	assert.Equal(t, diag.Diagnostics{
+		{Severity: diag.Warning, Summary: "workload vm is in error state", Detail: "failed to boot"},
+		{Severity: diag.Warning, Summary: "workload zdb is in deleted state", Detail: "contract canceled"},
+	}, diags)
+}
@@ -0,0 +1,98 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// newZDBDeploymentFromSchema reads the grid_zdb resource configuration data from schema.ResourceData and converts it
+// into a deployment holding the zdb on its node
+func newZDBDeploymentFromSchema(d *schema.ResourceData) (*workloads.Deployment, error) {
+	nodeID := uint32(d.Get("node").(int))
+	name := d.Get("name").(string)
+
+	var contractID uint64
+	if d.Id() != "" {
+		var err error
+		contractID, err = strconv.ParseUint(d.Id(), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nodeDeploymentID := map[uint32]uint64{}
+	if contractID != 0 {
+		nodeDeploymentID[nodeID] = contractID
+	}
+
+	solutionType := d.Get("solution_type").(string)
+	if solutionType == "" {
+		solutionType = fmt.Sprintf("zdb/%s", name)
+	}
+
+	return &workloads.Deployment{
+		Name:         name,
+		NodeID:       nodeID,
+		SolutionType: solutionType,
+		Zdbs: []workloads.ZDB{{
+			Name:        name,
+			Password:    d.Get("password").(string),
+			Public:      d.Get("public").(bool),
+			SizeGB:      uint64(d.Get("size").(int)),
+			Description: d.Get("description").(string),
+			Mode:        d.Get("mode").(string),
+		}},
+		ContractID:       contractID,
+		NodeDeploymentID: nodeDeploymentID,
+	}, nil
+}
+
+// deploymentZDB returns the only zdb of a deployment, and whether the deployment holds nothing else
+func deploymentZDB(dl *workloads.Deployment) (*workloads.ZDB, bool) {
+	if len(dl.Zdbs) != 1 {
+		return nil, false
+	}
+	only := len(dl.Vms) == 0 && len(dl.VmsLight) == 0 && len(dl.Disks) == 0 && len(dl.Volumes) == 0 && len(dl.QSFS) == 0
+	return &dl.Zdbs[0], only
+}
+
+// syncContractsZDB updates the terraform local state with the zdb deployed on the node
+func syncContractsZDB(d *schema.ResourceData, dl *workloads.Deployment) (errors error) {
+	d.SetId(fmt.Sprint(dl.ContractID))
+
+	zdb, _ := deploymentZDB(dl)
+	if zdb == nil {
+		// the zdb failed or the contract is gone, only the contract is tracked
+		return
+	}
+
+	values := map[string]interface{}{
+		"node":        dl.NodeID,
+		"name":        zdb.Name,
+		"size":        int(zdb.SizeGB),
+		"mode":        zdb.Mode,
+		"public":      zdb.Public,
+		"password":    zdb.Password,
+		"description": zdb.Description,
+		"ips":         zdb.IPs,
+		"namespace":   zdb.Namespace,
+		"port":        zdb.Port,
+	}
+	for key, value := range values {
+		if err := d.Set(key, value); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set %s with error: %w", key, err))
+		}
+	}
+
+	if dl.SolutionType != fmt.Sprintf("zdb/%s", zdb.Name) {
+		if err := d.Set("solution_type", dl.SolutionType); err != nil {
+			errors = multierror.Append(errors, fmt.Errorf("failed to set solution type with error: %w", err))
+		}
+	}
+	return
+}
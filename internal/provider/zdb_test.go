@@ -0,0 +1,74 @@
+// Package provider is the terraform provider
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func TestNewZDBDeploymentFromSchema(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceZDB().Schema, map[string]interface{}{
+		"name":     "backup",
+		"node":     11,
+		"size":     10,
+		"password": "pass",
+	})
+	d.SetId("42")
+
+	dl, err := newZDBDeploymentFromSchema(d)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup", dl.Name)
+	assert.Equal(t, "zdb/backup", dl.SolutionType)
+	assert.Equal(t, uint64(42), dl.ContractID)
+	assert.Equal(t, map[uint32]uint64{11: 42}, dl.NodeDeploymentID)
+	assert.Equal(t, []workloads.ZDB{{Name: "backup", Password: "pass", SizeGB: 10, Mode: workloads.ZDBModeUser}}, dl.Zdbs)
+}
+
+func TestSyncContractsZDB(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceZDB().Schema, map[string]interface{}{})
+	dl := &workloads.Deployment{
+		NodeID:       11,
+		ContractID:   42,
+		SolutionType: "backups",
+		Zdbs: []workloads.ZDB{{
+			Name: "backup", Password: "pass", SizeGB: 20, Mode: workloads.ZDBModeSeq,
+			IPs: []string{"2a02:1802::1", "301:1::1"}, Port: 9900, Namespace: "ns",
+		}},
+	}
+
+	assert.NoError(t, syncContractsZDB(d, dl))
+	assert.Equal(t, "42", d.Id())
+	assert.Equal(t, 11, d.Get("node"))
+	assert.Equal(t, 20, d.Get("size"))
+	assert.Equal(t, workloads.ZDBModeSeq, d.Get("mode"))
+	assert.Equal(t, "backups", d.Get("solution_type"))
+	assert.Equal(t, []interface{}{"2a02:1802::1", "301:1::1"}, d.Get("ips"))
+	assert.Equal(t, 9900, d.Get("port"))
+
+	dl.Vms = []workloads.VM{{Name: "vm"}}
+	_, only := deploymentZDB(dl)
+	assert.False(t, only)
+}
+
+func TestResourceZDBCustomizeDiff(t *testing.T) {
+	state := &terraform.InstanceState{
+		ID: "42",
+		Attributes: map[string]string{
+			"name": "backup", "node": "11", "size": "10", "mode": "user", "public": "false", "password": "", "description": "", "solution_type": "",
+		},
+	}
+	diff := func(size int) error {
+		_, err := resourceZDB().Diff(context.Background(), state, terraform.NewResourceConfigRaw(map[string]interface{}{
+			"name": "backup", "node": 11, "size": size,
+		}), nil)
+		return err
+	}
+
+	assert.NoError(t, diff(20))
+	assert.ErrorContains(t, diff(5), "size: can't shrink from 10 to 5 GBs")
+}